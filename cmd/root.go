@@ -51,14 +51,14 @@ func Execute() {
 }
 
 func init() {
-	// TODO: Add this back in but read in the gasp config files instead of the example code
-	//cobra.OnInitialize(initConfig)
+	// Read gasp-docker's own settings e.g. runtime from $HOME/.gasp-docker.yaml
+	// Note: the gasp config files are still read from the spec directory
+	cobra.OnInitialize(initConfig)
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
-	// TODO: Clean up example below
-	//rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.gasp-docker.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.gasp-docker.yaml)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -84,7 +84,8 @@ func initConfig() {
 		viper.SetConfigName(".gasp-docker")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	viper.SetEnvPrefix("gasp")
+	viper.AutomaticEnv() // read in environment variables that match e.g. GASP_RUNTIME
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
@@ -15,17 +15,22 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	g "github.com/appsecpipeline/gasp"
 	d "github.com/appsecpipeline/gasp-docker/gdocker"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // Vars to handle command-line args
 var Profile, AppName, Src, Rpt, Vol, AppProfile,
-	ToolProfile, Target, PipeType, Loc, Params string
-var Keep, DryRun bool
+	ToolProfile, Target, PipeType, Loc, Params, ExportVolume, SecretsFile, ParamsFile, AppConfDir, Sarif, JUnit string
+var Keep, KeepVolume, DryRun, ShowParams, JUnitFindings, Dojo bool
 
 // runCmd represents the run command
 var runCmd = &cobra.Command{
@@ -45,23 +50,68 @@ would run the pipeline called "pre-launch" as defined in master.yaml
 		// Take the run flags and fill the EventArgs struct
 		tc := make(map[string]string)
 		ev := g.EventArgs{
-			Profile,
-			AppName,
-			Target,
-			DryRun,
-			Keep,
-			Vol,
-			Src,
-			Rpt,
-			AppProfile,
-			ToolProfile,
-			Loc,
-			Params,
-			tc,
+			Profile:     Profile,
+			AppName:     AppName,
+			Target:      Target,
+			DryRun:      DryRun,
+			Keep:        Keep,
+			Vol:         Vol,
+			Src:         Src,
+			Rpt:         Rpt,
+			AppProfile:  AppProfile,
+			AppToolProf: ToolProfile,
+			Loc:         Loc,
+			ParamsRaw:   Params,
+			ToolConf:    tc,
 		}
 
 		// Load the pipeline for a run
-		d.LoadPipeline(&ev)
+		// The runtime can come from --runtime, GASP_RUNTIME or the runtime key in the config file
+		opts := d.RunOpts{
+			Runtime:      viper.GetString("runtime"),
+			KeepVolume:   KeepVolume,
+			ExportVolume: ExportVolume,
+			ParamsFile:   ParamsFile,
+			AppConfDir:   AppConfDir,
+			SecretsFile:  SecretsFile,
+			SARIF:        Sarif,
+			JUnit:        JUnit,
+			JUnitAll:     JUnitFindings,
+			Dojo:         Dojo,
+		}
+		r := d.NewRunner(ev, opts)
+
+		// Just show the parameters each tool would get
+		if ShowParams {
+			if err := r.ShowParams(os.Stdout); err != nil {
+				fmt.Printf("ERROR: %s\n", err)
+				os.Exit(d.ExitFailed)
+			}
+			os.Exit(d.ExitSuccess)
+		}
+
+		// Ctrl-C or SIGTERM cancels the run, stopping any running tool and
+		// cleaning up, a second one exits straight away
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sigs := make(chan os.Signal, 2)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			s := <-sigs
+			fmt.Printf("\nReceived %v, stopping the run and cleaning up - send it again to quit now\n", s)
+			cancel()
+			<-sigs
+			os.Exit(d.ExitCancelled)
+		}()
+
+		res, err := r.Run(ctx)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(d.ExitFailed)
+		}
+
+		// Exit based on how each step of the run went
+		os.Exit(res.ExitCode)
 	},
 }
 
@@ -116,7 +166,22 @@ func init() {
 		"keep",
 		"k",
 		false,
-		"If present, keep any containers used during the pipeline run")
+		"If present, keep any containers used during the pipeline run until the cleanup stage, and keep the ephemeral data volume")
+
+	runCmd.Flags().BoolVar(&KeepVolume,
+		"keep-volume",
+		false,
+		"If present, keep the ephemeral data volume instead of removing it in the cleanup stage")
+
+	runCmd.Flags().StringVar(&ExportVolume,
+		"export-volume",
+		"",
+		"The full path of a tarball to export the ephemeral data volume to before it's removed")
+
+	runCmd.Flags().StringVar(&SecretsFile,
+		"secrets-file",
+		"",
+		"The full path to a file of NAME=value lines with secrets like DOJO_API_KEY so they aren't on the command-line")
 
 	runCmd.Flags().StringVarP(&Vol,
 		"volume",
@@ -128,13 +193,33 @@ func init() {
 		"source",
 		"s",
 		"none",
-		"The full path to a local directory to use for /opt/appsecpipeline/reports")
+		"The full path to a local directory which contains source code for SAST pipeline runs, mounted at --location")
 
 	runCmd.Flags().StringVarP(&Rpt,
 		"reports",
 		"r",
 		"none",
-		"The full path to a local directory which contains source code for SAST pipeline runs")
+		"The full path to a local directory to collect each tool's report into as [reports]/[run id]/[stage]/[n]_[tool]/ with an index.json")
+
+	runCmd.Flags().StringVar(&Sarif,
+		"sarif",
+		"",
+		"The full path to a file to write the findings from every tool's report to as a SARIF 2.1.0 log")
+
+	runCmd.Flags().StringVar(&JUnit,
+		"junit",
+		"",
+		"The full path to a file to write the run's results to as JUnit XML, with a testsuite per stage and a testcase per tool")
+
+	runCmd.Flags().BoolVar(&JUnitFindings,
+		"junit-findings",
+		false,
+		"If present, add a failed testcase to the JUnit XML for each finding at or above min-severity from every tool, not just tools with a junit command")
+
+	runCmd.Flags().BoolVar(&Dojo,
+		"dojo",
+		false,
+		"If present, import each tool's report into DefectDojo with its API using the defectdojo tool's DOJO_* parameters instead of running the defectdojo container")
 
 	runCmd.Flags().StringVarP(&AppProfile,
 		"app-profile",
@@ -142,6 +227,11 @@ func init() {
 		"none",
 		"The application specific named pipeline (profile) to use for this run in [app-name]-pipeline.yaml")
 
+	runCmd.Flags().StringVar(&AppConfDir,
+		"app-config-dir",
+		"",
+		"The directory with [app-name]-pipeline.yaml and [app-name]-tool.yaml, defaults to the spec directory")
+
 	runCmd.Flags().StringVarP(&ToolProfile,
 		"tool-profile",
 		"o",
@@ -152,12 +242,27 @@ func init() {
 		"location",
 		"l",
 		"/opt/appsecpipeline/source",
-		"Path to where the sourcecode is in the container")
+		"Path to where the sourcecode is in the container, also the default LOC parameter for tools")
 
 	runCmd.Flags().StringVarP(&Params,
 		"params",
 		"m",
 		"",
-		"Required parametetrs for the pipeline tools in this run")
+		"Required parametetrs for the pipeline tools in this run as NAME=value or tool.NAME=value (just for that tool) separated by spaces, quote values with spaces e.g. \"LOC=/src bandit.LOC=/src/app LOGIN_PARMS='user=a&password=b'\"")
+
+	runCmd.Flags().StringVar(&ParamsFile,
+		"params-file",
+		"",
+		"The full path to a YAML (.yaml/.yml), JSON (.json) or .env file of tool parameters, --params and GASP_PARAM_* environment variables override it")
+
+	runCmd.Flags().BoolVar(&ShowParams,
+		"show-params",
+		false,
+		"If present, show the parameters each tool would get and where they came from, with secrets masked, then exit without running anything")
+
+	runCmd.Flags().String("runtime",
+		"docker",
+		"The container runtime to use - docker (docker cli), docker-api (Docker Engine API at DOCKER_HOST or /var/run/docker.sock) or podman (podman cli, rootless supported)")
+	viper.BindPFlag("runtime", runCmd.Flags().Lookup("runtime"))
 
 }
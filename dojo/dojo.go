@@ -0,0 +1,284 @@
+// dojo
+package dojo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefectDojo's scan_type for the report of each tool in
+// secpipeline-config.yaml that DefectDojo can import
+var scanTypes = map[string]string{
+	"appspider":        "AppSpider Scan",
+	"arachni":          "Arachni Scan",
+	"bandit":           "Bandit Scan",
+	"brakeman":         "Brakeman Scan",
+	"checkmarx":        "Checkmarx Scan",
+	"dependency-check": "Dependency Check Scan",
+	"nikto":            "Nikto Scan",
+	"nmap":             "Nmap Scan",
+	"retirejs":         "Retire.js Scan",
+	"snyk":             "Snyk Scan",
+	"spotbugs":         "SpotBugs Scan",
+	"zap":              "ZAP Scan",
+}
+
+// ScanType returns DefectDojo's scan_type for a tool's report and true if
+// DefectDojo can import it
+func ScanType(tool string) (string, bool) {
+	st, ok := scanTypes[tool]
+	return st, ok
+}
+
+// Client talks to DefectDojo's v2 API
+type Client struct {
+	Host   string // base URL of DefectDojo e.g. https://dojo.example.com
+	apiKey string
+	http   *http.Client
+}
+
+// NewClient returns a Client for the DefectDojo at host using apiKey.  host
+// without a scheme is https and proxy, if not empty, is the URL of an HTTP
+// proxy to connect through.
+func NewClient(host string, apiKey string, proxy string) (*Client, error) {
+	if host == "" {
+		return nil, fmt.Errorf("no DefectDojo host")
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("no DefectDojo API key")
+	}
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	u, err := url.Parse(host)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("'%s' is not a valid DefectDojo host", host)
+	}
+
+	tr := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if proxy != "" {
+		p, err := url.Parse(proxy)
+		if err != nil || p.Host == "" {
+			return nil, fmt.Errorf("'%s' is not a valid proxy URL", proxy)
+		}
+		tr.Proxy = http.ProxyURL(p)
+	}
+
+	return &Client{
+		Host:   strings.TrimRight(host, "/"),
+		apiKey: apiKey,
+		http:   &http.Client{Transport: tr, Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// APIError is a non-2xx response from DefectDojo
+type APIError struct {
+	Method string
+	Path   string
+	Status int
+	Body   string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s returned %d %s: %s", e.Method, e.Path, e.Status, http.StatusText(e.Status), e.Body)
+}
+
+// Product is a DefectDojo product, gasp-docker's app
+type Product struct {
+	ID          int    `json:"id,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ProdType    int    `json:"prod_type"`
+}
+
+// Engagement is a DefectDojo engagement which scans are imported into
+type Engagement struct {
+	ID             int    `json:"id,omitempty"`
+	Name           string `json:"name"`
+	Description    string `json:"description,omitempty"`
+	Product        int    `json:"product"`
+	TargetStart    string `json:"target_start"` // YYYY-MM-DD
+	TargetEnd      string `json:"target_end"`   // YYYY-MM-DD
+	EngagementType string `json:"engagement_type,omitempty"`
+	Status         string `json:"status,omitempty"`
+	BuildID        string `json:"build_id,omitempty"`
+}
+
+// Scan is a tool's report to import into an engagement
+type Scan struct {
+	Engagement  int
+	ScanType    string // see ScanType
+	FileName    string
+	Data        []byte
+	MinSeverity string // Info, Low, Medium, High or Critical
+	ScanDate    string // YYYY-MM-DD, defaults to today
+	Tags        []string
+}
+
+// ImportResult is what DefectDojo made of an imported scan
+type ImportResult struct {
+	Test       int    `json:"test"`
+	Engagement int    `json:"engagement"`
+	ScanType   string `json:"scan_type"`
+}
+
+// Product returns the product with the ID
+func (c *Client) Product(ctx context.Context, id int) (*Product, error) {
+	p := &Product{}
+	if err := c.do(ctx, "GET", "/api/v2/products/"+strconv.Itoa(id)+"/", nil, "", p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ProductByName returns the product called name, nil if there isn't one
+func (c *Client) ProductByName(ctx context.Context, name string) (*Product, error) {
+	var list struct {
+		Results []Product `json:"results"`
+	}
+	if err := c.do(ctx, "GET", "/api/v2/products/?name="+url.QueryEscape(name), nil, "", &list); err != nil {
+		return nil, err
+	}
+	// name is a partial match in some versions of DefectDojo
+	for _, p := range list.Results {
+		if p.Name == name {
+			return &p, nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateProduct adds a product, returning it with its ID
+func (c *Client) CreateProduct(ctx context.Context, p Product) (*Product, error) {
+	created := &Product{}
+	if err := c.doJSON(ctx, "POST", "/api/v2/products/", p, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// Engagement returns the engagement with the ID
+func (c *Client) Engagement(ctx context.Context, id int) (*Engagement, error) {
+	e := &Engagement{}
+	if err := c.do(ctx, "GET", "/api/v2/engagements/"+strconv.Itoa(id)+"/", nil, "", e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// CreateEngagement adds an engagement, returning it with its ID
+func (c *Client) CreateEngagement(ctx context.Context, e Engagement) (*Engagement, error) {
+	created := &Engagement{}
+	if err := c.doJSON(ctx, "POST", "/api/v2/engagements/", e, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// CloseEngagement closes an engagement once everything has been imported
+func (c *Client) CloseEngagement(ctx context.Context, id int) error {
+	return c.do(ctx, "POST", "/api/v2/engagements/"+strconv.Itoa(id)+"/close/", nil, "", nil)
+}
+
+// ImportScan uploads a tool's report into an engagement
+func (c *Client) ImportScan(ctx context.Context, s Scan) (*ImportResult, error) {
+	if s.ScanDate == "" {
+		s.ScanDate = time.Now().Format("2006-01-02")
+	}
+	if s.MinSeverity == "" {
+		s.MinSeverity = "Info"
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fields := [][2]string{
+		{"engagement", strconv.Itoa(s.Engagement)},
+		{"scan_type", s.ScanType},
+		{"scan_date", s.ScanDate},
+		{"minimum_severity", s.MinSeverity},
+		{"active", "true"},
+		{"verified", "false"},
+	}
+	for _, t := range s.Tags {
+		fields = append(fields, [2]string{"tags", t})
+	}
+	for _, f := range fields {
+		if err := mw.WriteField(f[0], f[1]); err != nil {
+			return nil, err
+		}
+	}
+	fw, err := mw.CreateFormFile("file", s.FileName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(s.Data); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	res := &ImportResult{}
+	if err := c.do(ctx, "POST", "/api/v2/import-scan/", &body, mw.FormDataContentType(), res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Send v as JSON
+func (c *Client) doJSON(ctx context.Context, method string, path string, v interface{}, out interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, method, path, bytes.NewReader(b), "application/json", out)
+}
+
+// Make an API call, decoding a JSON response into out if it's not nil
+func (c *Client) do(ctx context.Context, method string, path string, body io.Reader, contentType string, out interface{}) error {
+	req, err := http.NewRequest(method, c.Host+path, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Token "+c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &APIError{Method: method, Path: path, Status: resp.StatusCode, Body: strings.TrimSpace(string(b))}
+	}
+	if out == nil || len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("unable to read the response to %s %s: %v", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// dojo
+package dojo
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A Client for a fake DefectDojo which handles every request with h, call
+// the returned func when done
+func newTestClient(t *testing.T, h http.HandlerFunc) (*Client, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Token s3cret" {
+			t.Errorf("%s %s Authorization = %q, want Token s3cret", r.Method, r.URL.Path, got)
+		}
+		h(w, r)
+	}))
+	c, err := NewClient(srv.URL, "s3cret", "")
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return c, srv.Close
+}
+
+// Check the method and path of a request, failing it with a 404 if they're wrong
+func expect(t *testing.T, w http.ResponseWriter, r *http.Request, method string, path string) bool {
+	if r.Method != method || r.URL.Path != path {
+		t.Errorf("request = %s %s, want %s %s", r.Method, r.URL.Path, method, path)
+		http.NotFound(w, r)
+		return false
+	}
+	return true
+}
+
+func TestProductByName(t *testing.T) {
+	c, done := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if !expect(t, w, r, "GET", "/api/v2/products/") {
+			return
+		}
+		// Older DefectDojos match the name partially
+		switch r.URL.Query().Get("name") {
+		case "myapp":
+			w.Write([]byte(`{"count": 2, "results": [{"id": 3, "name": "myapp-legacy"}, {"id": 7, "name": "myapp", "prod_type": 1}]}`))
+		default:
+			w.Write([]byte(`{"count": 1, "results": [{"id": 3, "name": "myapp-legacy"}]}`))
+		}
+	})
+	defer done()
+
+	p, err := c.ProductByName(context.Background(), "myapp")
+	if err != nil {
+		t.Fatalf("ProductByName failed: %v", err)
+	}
+	if p == nil || p.ID != 7 || p.Name != "myapp" {
+		t.Errorf("ProductByName(myapp) = %+v, want ID 7", p)
+	}
+
+	p, err = c.ProductByName(context.Background(), "my")
+	if err != nil || p != nil {
+		t.Errorf("ProductByName(my) = %+v, %v, want nil as there's only a partial match", p, err)
+	}
+}
+
+func TestCreateProduct(t *testing.T) {
+	c, done := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if !expect(t, w, r, "POST", "/api/v2/products/") {
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %s, want application/json", ct)
+		}
+		var p Product
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("unable to decode the product: %v", err)
+		}
+		if p.Name != "myapp" || p.ProdType != 1 {
+			t.Errorf("product sent = %+v", p)
+		}
+		p.ID = 12
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(p)
+	})
+	defer done()
+
+	p, err := c.CreateProduct(context.Background(), Product{Name: "myapp", Description: "Created by gasp-docker", ProdType: 1})
+	if err != nil {
+		t.Fatalf("CreateProduct failed: %v", err)
+	}
+	if p.ID != 12 || p.Name != "myapp" {
+		t.Errorf("CreateProduct = %+v, want ID 12", p)
+	}
+}
+
+func TestCreateEngagement(t *testing.T) {
+	c, done := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if !expect(t, w, r, "POST", "/api/v2/engagements/") {
+			return
+		}
+		var e map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("unable to decode the engagement: %v", err)
+		}
+		if e["name"] != "gasp-docker run 1234" || e["product"] != float64(12) || e["target_start"] != "2019-06-12" || e["engagement_type"] != "CI/CD" {
+			t.Errorf("engagement sent = %v", e)
+		}
+		// Unset optional fields aren't sent
+		if _, ok := e["id"]; ok {
+			t.Errorf("engagement sent with an id: %v", e)
+		}
+		e["id"] = 40
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(e)
+	})
+	defer done()
+
+	e, err := c.CreateEngagement(context.Background(), Engagement{
+		Name:           "gasp-docker run 1234",
+		Product:        12,
+		TargetStart:    "2019-06-12",
+		TargetEnd:      "2019-06-12",
+		EngagementType: "CI/CD",
+	})
+	if err != nil {
+		t.Fatalf("CreateEngagement failed: %v", err)
+	}
+	if e.ID != 40 || e.Product != 12 {
+		t.Errorf("CreateEngagement = %+v, want ID 40", e)
+	}
+}
+
+func TestImportScan(t *testing.T) {
+	c, done := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if !expect(t, w, r, "POST", "/api/v2/import-scan/") {
+			return
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("unable to parse the multipart form: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		want := map[string]string{
+			"engagement":       "40",
+			"scan_type":        "Bandit Scan",
+			"scan_date":        time.Now().Format("2006-01-02"),
+			"minimum_severity": "Info",
+			"active":           "true",
+			"verified":         "false",
+		}
+		for k, v := range want {
+			if got := r.FormValue(k); got != v {
+				t.Errorf("%s = %q, want %q", k, got, v)
+			}
+		}
+		if tags := strings.Join(r.MultipartForm.Value["tags"], " "); tags != "gasp-docker bandit" {
+			t.Errorf("tags = %s, want gasp-docker bandit", tags)
+		}
+
+		f, fh, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("no file sent: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		data, _ := ioutil.ReadAll(f)
+		if fh.Filename != "1234.json" || string(data) != `{"results": []}` {
+			t.Errorf("file = %s with %q", fh.Filename, data)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"test": 88, "engagement": 40, "scan_type": "Bandit Scan"}`))
+	})
+	defer done()
+
+	res, err := c.ImportScan(context.Background(), Scan{
+		Engagement: 40,
+		ScanType:   "Bandit Scan",
+		FileName:   "1234.json",
+		Data:       []byte(`{"results": []}`),
+		Tags:       []string{"gasp-docker", "bandit"},
+	})
+	if err != nil {
+		t.Fatalf("ImportScan failed: %v", err)
+	}
+	if res.Test != 88 || res.Engagement != 40 {
+		t.Errorf("ImportScan = %+v, want test 88", res)
+	}
+}
+
+func TestCloseEngagement(t *testing.T) {
+	closed := false
+	c, done := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if !expect(t, w, r, "POST", "/api/v2/engagements/40/close/") {
+			return
+		}
+		closed = true
+		w.WriteHeader(http.StatusOK)
+	})
+	defer done()
+
+	if err := c.CloseEngagement(context.Background(), 40); err != nil {
+		t.Fatalf("CloseEngagement failed: %v", err)
+	}
+	if !closed {
+		t.Errorf("engagement 40 wasn't closed")
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	c, done := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"detail": "You do not have permission to perform this action."}` + "\n"))
+	})
+	defer done()
+
+	_, err := c.Engagement(context.Background(), 40)
+	ae, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("Engagement returned %T %v, want an *APIError", err, err)
+	}
+	if ae.Method != "GET" || ae.Path != "/api/v2/engagements/40/" || ae.Status != http.StatusForbidden || ae.Body != `{"detail": "You do not have permission to perform this action."}` {
+		t.Errorf("APIError = %+v", ae)
+	}
+	if !strings.Contains(ae.Error(), "returned 403 Forbidden") {
+		t.Errorf("Error() = %s", ae.Error())
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	c, err := NewClient("dojo.example.com/", "s3cret", "")
+	if err != nil || c.Host != "https://dojo.example.com" {
+		t.Errorf("NewClient = %+v, %v, want https://dojo.example.com", c, err)
+	}
+	for _, tt := range []struct{ host, key, proxy string }{
+		{"", "s3cret", ""},
+		{"dojo.example.com", "", ""},
+		{"dojo.example.com", "s3cret", "::nope"},
+	} {
+		if _, err := NewClient(tt.host, tt.key, tt.proxy); err == nil {
+			t.Errorf("NewClient(%q, %q, %q) didn't return an error", tt.host, tt.key, tt.proxy)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// findings
+package findings
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// arachni_reporter's json report, which the arachni tool's post command
+// writes to [reportname].json
+type arachniReport struct {
+	Issues []struct {
+		Name     string `json:"name"`
+		Severity string `json:"severity"`
+		CWE      int    `json:"cwe"`
+		Check    struct {
+			Shortname string `json:"shortname"`
+		} `json:"check"`
+		Vector struct {
+			URL    string `json:"url"`
+			Action string `json:"action"`
+			Input  string `json:"affected_input_name"`
+		} `json:"vector"`
+		Proof string `json:"proof"`
+	} `json:"issues"`
+}
+
+func parseArachni(r io.Reader) ([]Finding, error) {
+	var rpt arachniReport
+	if err := json.NewDecoder(r).Decode(&rpt); err != nil {
+		return nil, err
+	}
+
+	fs := make([]Finding, 0, len(rpt.Issues))
+	for _, is := range rpt.Issues {
+		loc := is.Vector.Action
+		if loc == "" {
+			loc = is.Vector.URL
+		}
+		ev := is.Proof
+		if is.Vector.Input != "" {
+			ev = is.Vector.Input + ": " + ev
+		}
+		fs = append(fs, Finding{
+			RuleID:   is.Check.Shortname,
+			Severity: Severity(is.Severity),
+			Title:    is.Name,
+			Location: loc,
+			CWE:      is.CWE,
+			Evidence: ev,
+		})
+	}
+	return fs, nil
+}
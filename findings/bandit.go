@@ -0,0 +1,43 @@
+// findings
+package findings
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// bandit's -f json report
+type banditReport struct {
+	Results []struct {
+		Code     string `json:"code"`
+		Filename string `json:"filename"`
+		Severity string `json:"issue_severity"`
+		Text     string `json:"issue_text"`
+		Line     int    `json:"line_number"`
+		TestID   string `json:"test_id"`
+		CWE      struct {
+			ID int `json:"id"`
+		} `json:"issue_cwe"`
+	} `json:"results"`
+}
+
+func parseBandit(r io.Reader) ([]Finding, error) {
+	var rpt banditReport
+	if err := json.NewDecoder(r).Decode(&rpt); err != nil {
+		return nil, err
+	}
+
+	fs := make([]Finding, 0, len(rpt.Results))
+	for _, res := range rpt.Results {
+		fs = append(fs, Finding{
+			RuleID:   res.TestID,
+			Severity: Severity(res.Severity),
+			Title:    res.Text,
+			Location: res.Filename,
+			Line:     res.Line,
+			CWE:      res.CWE.ID,
+			Evidence: res.Code,
+		})
+	}
+	return fs, nil
+}
@@ -0,0 +1,53 @@
+// findings
+package findings
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// dependency-check's --format XML report.  Element names are matched
+// whatever the schema version's namespace is.
+type depCheckReport struct {
+	Dependencies []struct {
+		FileName        string `xml:"fileName"`
+		FilePath        string `xml:"filePath"`
+		Vulnerabilities []struct {
+			Name        string   `xml:"name"`
+			Severity    string   `xml:"severity"`
+			CWE         string   `xml:"cwe"`      // older schemas
+			CWEs        []string `xml:"cwes>cwe"` // newer schemas
+			Description string   `xml:"description"`
+		} `xml:"vulnerabilities>vulnerability"`
+	} `xml:"dependencies>dependency"`
+}
+
+func parseDependencyCheck(r io.Reader) ([]Finding, error) {
+	var rpt depCheckReport
+	if err := xml.NewDecoder(r).Decode(&rpt); err != nil {
+		return nil, err
+	}
+
+	fs := make([]Finding, 0)
+	for _, d := range rpt.Dependencies {
+		loc := d.FilePath
+		if loc == "" {
+			loc = d.FileName
+		}
+		for _, v := range d.Vulnerabilities {
+			cwe := parseCWE(v.CWE)
+			if cwe == 0 && len(v.CWEs) > 0 {
+				cwe = parseCWE(v.CWEs[0])
+			}
+			fs = append(fs, Finding{
+				RuleID:   v.Name,
+				Severity: Severity(v.Severity),
+				Title:    v.Name + " in " + d.FileName,
+				Location: loc,
+				CWE:      cwe,
+				Evidence: v.Description,
+			})
+		}
+	}
+	return fs, nil
+}
@@ -0,0 +1,160 @@
+// findings
+package findings
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severities of a Finding, lowest to highest
+const (
+	SevInfo     = "info"
+	SevLow      = "low"
+	SevMedium   = "medium"
+	SevHigh     = "high"
+	SevCritical = "critical"
+)
+
+var sevRank = map[string]int{
+	SevInfo:     0,
+	SevLow:      1,
+	SevMedium:   2,
+	SevHigh:     3,
+	SevCritical: 4,
+}
+
+// Finding is a single issue reported by a tool, whatever the tool's report
+// format was
+type Finding struct {
+	Tool     string `json:"tool"`               // the tool's name in secpipeline-config.yaml e.g. bandit
+	RuleID   string `json:"rule-id"`            // the tool's ID for the check or vulnerability e.g. B101 or CVE-2019-10744
+	Severity string `json:"severity"`           // one of the Sev* constants
+	Title    string `json:"title"`              // short description of the issue
+	Location string `json:"location"`           // file, URL, host:port or package the issue is in
+	Line     int    `json:"line,omitempty"`     // line in Location, for source code findings
+	CWE      int    `json:"cwe,omitempty"`      // CWE ID e.g. 79, zero if the tool didn't give one
+	Evidence string `json:"evidence,omitempty"` // code, request parameter, proof etc from the report
+}
+
+// A Parser reads a tool's report into Findings
+type Parser func(r io.Reader) ([]Finding, error)
+
+// Parsers keyed by the tool's name in secpipeline-config.yaml
+var parsers = map[string]Parser{
+	"arachni":          parseArachni,
+	"bandit":           parseBandit,
+	"dependency-check": parseDependencyCheck,
+	"nikto":            parseNikto,
+	"nmap":             parseNmap,
+	"retirejs":         parseRetireJS,
+	"zap":              parseZap,
+}
+
+// Supported returns true if there's a parser for a tool's report
+func Supported(tool string) bool {
+	_, ok := parsers[tool]
+	return ok
+}
+
+// Tools returns the tools there are parsers for, in name order
+func Tools() []string {
+	t := make([]string, 0, len(parsers))
+	for name := range parsers {
+		t = append(t, name)
+	}
+	sort.Strings(t)
+	return t
+}
+
+// Parse reads a report from tool into Findings
+func Parse(tool string, r io.Reader) ([]Finding, error) {
+	p, ok := parsers[tool]
+	if !ok {
+		return nil, fmt.Errorf("no parser for %s reports", tool)
+	}
+	fs, err := p(r)
+	if err == io.EOF {
+		// The decoders hit the end before anything at all
+		err = errors.New("the report is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s report: %v", tool, err)
+	}
+	for i := range fs {
+		fs[i].Tool = tool
+		fs[i].Title = strings.TrimSpace(fs[i].Title)
+		fs[i].Evidence = strings.TrimSpace(fs[i].Evidence)
+	}
+	return fs, nil
+}
+
+// ParseFile reads a report file from tool into Findings
+func ParseFile(tool string, file string) ([]Finding, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(tool, f)
+}
+
+// Severity maps a tool's severity e.g. HIGH, Moderate or informational to
+// one of the Sev* constants.  Anything not recognised is info.
+func Severity(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "critical":
+		return SevCritical
+	case "high":
+		return SevHigh
+	case "medium", "moderate":
+		return SevMedium
+	case "low":
+		return SevLow
+	}
+	return SevInfo
+}
+
+// Known returns true if sev is one of the Sev* constants, in any case
+func Known(sev string) bool {
+	_, ok := sevRank[strings.ToLower(strings.TrimSpace(sev))]
+	return ok
+}
+
+// Rank orders severities, higher is more severe.  An unknown severity ranks
+// as info.
+func Rank(sev string) int {
+	return sevRank[Severity(sev)]
+}
+
+// AtLeast returns true if sev is min or more severe
+func AtLeast(sev string, min string) bool {
+	return Rank(sev) >= Rank(min)
+}
+
+// Count the findings of each severity
+func Count(fs []Finding) map[string]int {
+	c := map[string]int{SevInfo: 0, SevLow: 0, SevMedium: 0, SevHigh: 0, SevCritical: 0}
+	for _, f := range fs {
+		c[Severity(f.Severity)]++
+	}
+	return c
+}
+
+// CWE IDs are written all sorts of ways e.g. 79, CWE-79 or "CWE-79: Improper
+// Neutralization..."
+func parseCWE(s string) int {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.ToUpper(s), "CWE-")
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
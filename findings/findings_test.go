@@ -0,0 +1,133 @@
+// findings
+package findings
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A real report from each tool in testdata and what should be read from it
+var reports = []struct {
+	tool  string
+	file  string
+	count map[string]int
+	first Finding // the first finding read from the report
+}{
+	{"bandit", "bandit.json",
+		map[string]int{SevHigh: 1, SevMedium: 1, SevLow: 2},
+		Finding{Tool: "bandit", RuleID: "B403", Severity: SevLow, Location: "/opt/appsecpipeline/source/app/views.py", Line: 1, CWE: 502}},
+	{"arachni", "arachni.json",
+		map[string]int{SevHigh: 2, SevInfo: 1},
+		Finding{Tool: "arachni", RuleID: "xss", Severity: SevHigh, Location: "http://testphp.vulnweb.com/search.php?test=query", CWE: 79}},
+	{"dependency-check", "dependency-check.xml",
+		map[string]int{SevCritical: 1, SevHigh: 1, SevMedium: 1},
+		Finding{Tool: "dependency-check", RuleID: "CVE-2015-7501", Severity: SevCritical, Location: "/opt/appsecpipeline/source/lib/commons-collections-3.2.1.jar", CWE: 502}},
+	{"nikto", "nikto.xml",
+		map[string]int{SevLow: 4},
+		Finding{Tool: "nikto", RuleID: "999986", Severity: SevLow, Location: "http://testphp.vulnweb.com:80/"}},
+	{"nmap", "nmap.xml",
+		map[string]int{SevInfo: 4},
+		Finding{Tool: "nmap", RuleID: "open-port", Severity: SevInfo, Location: "45.33.32.156:22"}},
+	{"retirejs", "retirejs.json",
+		map[string]int{SevHigh: 1, SevMedium: 2, SevLow: 2},
+		Finding{Tool: "retirejs", RuleID: "CVE-2015-9251", Severity: SevMedium, Location: "/opt/appsecpipeline/source/static/js/jquery-1.8.1.min.js"}},
+	{"zap", "zap.xml",
+		map[string]int{SevHigh: 1, SevLow: 3, SevInfo: 1},
+		Finding{Tool: "zap", RuleID: "10016", Severity: SevLow, Location: "http://testphp.vulnweb.com/", CWE: 933}},
+}
+
+func readTestdata(t *testing.T, file string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range reports {
+		fs, err := ParseFile(tt.tool, filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Errorf("%s: %v", tt.tool, err)
+			continue
+		}
+
+		c := Count(fs)
+		total := 0
+		for _, sev := range []string{SevCritical, SevHigh, SevMedium, SevLow, SevInfo} {
+			total += tt.count[sev]
+			if c[sev] != tt.count[sev] {
+				t.Errorf("%s: %d %s findings, want %d", tt.tool, c[sev], sev, tt.count[sev])
+			}
+		}
+		if len(fs) != total {
+			t.Errorf("%s: %d findings, want %d", tt.tool, len(fs), total)
+		}
+		if len(fs) == 0 {
+			continue
+		}
+
+		f := fs[0]
+		w := tt.first
+		if f.Tool != w.Tool || f.RuleID != w.RuleID || f.Severity != w.Severity || f.Location != w.Location || f.Line != w.Line || f.CWE != w.CWE {
+			t.Errorf("%s: first finding = %+v, want %+v", tt.tool, f, w)
+		}
+		for _, f := range fs {
+			if f.Title == "" {
+				t.Errorf("%s: finding with no title %+v", tt.tool, f)
+			}
+		}
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	for _, tt := range reports {
+		_, err := Parse(tt.tool, strings.NewReader(""))
+		if tt.tool == "retirejs" {
+			// retire writes nothing at all when it has nothing to report
+			if err != nil {
+				t.Errorf("%s: empty report returned %v", tt.tool, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: empty report parsed without an error", tt.tool)
+		}
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	for _, tt := range reports {
+		data := readTestdata(t, tt.file)
+		fs, err := Parse(tt.tool, strings.NewReader(string(data[:len(data)/2])))
+		if err == nil {
+			t.Errorf("%s: truncated report parsed without an error, got %d findings", tt.tool, len(fs))
+		}
+	}
+}
+
+func TestParseOtherXML(t *testing.T) {
+	// A report from the wrong tool shouldn't pass for an empty nikto scan
+	if _, err := Parse("nikto", strings.NewReader(string(readTestdata(t, "nmap.xml")))); err == nil {
+		t.Errorf("nikto: nmap report parsed without an error")
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"CRITICAL", SevCritical},
+		{"High", SevHigh},
+		{"moderate", SevMedium},
+		{" medium ", SevMedium},
+		{"LOW", SevLow},
+		{"informational", SevInfo},
+		{"", SevInfo},
+	}
+	for _, tt := range tests {
+		if got := Severity(tt.in); got != tt.want {
+			t.Errorf("Severity(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// findings
+package findings
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"strings"
+)
+
+// A host nikto scanned, newer versions nest these in more than one
+// niktoscan element so they're picked out wherever they are
+type niktoScan struct {
+	TargetIP   string `xml:"targetip,attr"`
+	TargetHost string `xml:"targethostname,attr"`
+	TargetPort string `xml:"targetport,attr"`
+	SiteName   string `xml:"sitename,attr"`
+	Items      []struct {
+		ID          string `xml:"id,attr"`
+		OSVDB       string `xml:"osvdbid,attr"`
+		Method      string `xml:"method,attr"`
+		Description string `xml:"description"`
+		URI         string `xml:"uri"`
+		NameLink    string `xml:"namelink"`
+	} `xml:"item"`
+}
+
+// nikto's -Format xml report.  nikto doesn't rate its findings so they're
+// all low.
+func parseNikto(r io.Reader) ([]Finding, error) {
+	fs := make([]Finding, 0)
+	scan := false // seen a niktoscan element, anything else isn't a nikto report
+	d := xml.NewDecoder(r)
+	for {
+		t, err := d.Token()
+		if err == io.EOF && !scan {
+			return nil, errors.New("no niktoscan element found")
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		se, ok := t.(xml.StartElement)
+		if ok && se.Name.Local == "niktoscan" {
+			scan = true
+		}
+		if !ok || se.Name.Local != "scandetails" {
+			continue
+		}
+
+		var s niktoScan
+		if err := d.DecodeElement(&s, &se); err != nil {
+			return nil, err
+		}
+		host := s.TargetHost
+		if host == "" {
+			host = s.TargetIP
+		}
+		for _, it := range s.Items {
+			loc := it.NameLink
+			if loc == "" {
+				loc = net.JoinHostPort(host, s.TargetPort) + it.URI
+			}
+			ev := ""
+			if it.OSVDB != "" && it.OSVDB != "0" {
+				ev = "OSVDB-" + it.OSVDB
+			}
+			fs = append(fs, Finding{
+				RuleID:   it.ID,
+				Severity: SevLow,
+				Title:    strings.TrimSpace(it.Description),
+				Location: loc,
+				Evidence: ev,
+			})
+		}
+	}
+	return fs, nil
+}
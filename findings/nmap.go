@@ -0,0 +1,94 @@
+// findings
+package findings
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// nmap's -oX report
+type nmapReport struct {
+	Hosts []struct {
+		Addresses []struct {
+			Addr string `xml:"addr,attr"`
+			Type string `xml:"addrtype,attr"`
+		} `xml:"address"`
+		Hostnames []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"hostnames>hostname"`
+		Ports []struct {
+			Protocol string `xml:"protocol,attr"`
+			PortID   string `xml:"portid,attr"`
+			State    struct {
+				State string `xml:"state,attr"`
+			} `xml:"state"`
+			Service struct {
+				Name    string `xml:"name,attr"`
+				Product string `xml:"product,attr"`
+				Version string `xml:"version,attr"`
+			} `xml:"service"`
+			Scripts []struct {
+				ID     string `xml:"id,attr"`
+				Output string `xml:"output,attr"`
+			} `xml:"script"`
+		} `xml:"ports>port"`
+	} `xml:"host"`
+}
+
+// Each open port is an info finding, as is the output of any scripts run
+// against it
+func parseNmap(r io.Reader) ([]Finding, error) {
+	var rpt nmapReport
+	if err := xml.NewDecoder(r).Decode(&rpt); err != nil {
+		return nil, err
+	}
+
+	fs := make([]Finding, 0)
+	for _, h := range rpt.Hosts {
+		// Prefer an IP address, then a hostname
+		host := ""
+		for _, a := range h.Addresses {
+			if a.Type == "ipv4" || a.Type == "ipv6" {
+				host = a.Addr
+				break
+			}
+		}
+		if host == "" && len(h.Hostnames) > 0 {
+			host = h.Hostnames[0].Name
+		}
+
+		for _, p := range h.Ports {
+			if p.State.State != "open" {
+				continue
+			}
+			loc := net.JoinHostPort(host, p.PortID)
+			svc := strings.TrimSpace(strings.Join([]string{p.Service.Product, p.Service.Version}, " "))
+			title := fmt.Sprintf("Open port %s/%s", p.PortID, p.Protocol)
+			if p.Service.Name != "" {
+				title += " " + p.Service.Name
+			}
+			if svc != "" {
+				title += " (" + svc + ")"
+			}
+			fs = append(fs, Finding{
+				RuleID:   "open-port",
+				Severity: SevInfo,
+				Title:    title,
+				Location: loc,
+			})
+			for _, s := range p.Scripts {
+				fs = append(fs, Finding{
+					RuleID:   s.ID,
+					Severity: SevInfo,
+					Title:    fmt.Sprintf("%s on port %s/%s", s.ID, p.PortID, p.Protocol),
+					Location: loc,
+					Evidence: s.Output,
+				})
+			}
+		}
+	}
+	return fs, nil
+}
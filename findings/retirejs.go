@@ -0,0 +1,87 @@
+// findings
+package findings
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// A file retire.js found vulnerable components in
+type retireFile struct {
+	File    string `json:"file"`
+	Results []struct {
+		Component       string `json:"component"`
+		Version         string `json:"version"`
+		Vulnerabilities []struct {
+			Severity    string `json:"severity"`
+			Identifiers struct {
+				Summary string   `json:"summary"`
+				CVE     []string `json:"CVE"`
+				Issue   string   `json:"issue"`
+				Bug     string   `json:"bug"`
+			} `json:"identifiers"`
+			Info []string `json:"info"`
+		} `json:"vulnerabilities"`
+	} `json:"results"`
+}
+
+// retire's --outputformat json report is a list of files or, in newer
+// versions, an object with the list in data
+func parseRetireJS(r io.Reader) ([]Finding, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []retireFile
+	b = bytes.TrimSpace(b)
+	switch {
+	case len(b) == 0:
+		// retire writes nothing when there's nothing to report
+	case b[0] == '[':
+		err = json.Unmarshal(b, &files)
+	default:
+		var rpt struct {
+			Data []retireFile `json:"data"`
+		}
+		err = json.Unmarshal(b, &rpt)
+		files = rpt.Data
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fs := make([]Finding, 0)
+	for _, f := range files {
+		for _, res := range f.Results {
+			for _, v := range res.Vulnerabilities {
+				id := v.Identifiers
+				rule := ""
+				switch {
+				case len(id.CVE) > 0:
+					rule = id.CVE[0]
+				case id.Issue != "":
+					rule = "issue-" + id.Issue
+				case id.Bug != "":
+					rule = "bug-" + id.Bug
+				}
+				title := id.Summary
+				if title == "" {
+					title = "Vulnerable component"
+				}
+				fs = append(fs, Finding{
+					RuleID:   rule,
+					Severity: Severity(v.Severity),
+					Title:    fmt.Sprintf("%s %s: %s", res.Component, res.Version, title),
+					Location: f.File,
+					Evidence: strings.Join(v.Info, "\n"),
+				})
+			}
+		}
+	}
+	return fs, nil
+}
@@ -0,0 +1,98 @@
+// findings
+package findings
+
+import (
+	"encoding/xml"
+	"io"
+	"regexp"
+)
+
+// A ZAP alert, the report lists them per site
+type zapAlert struct {
+	PluginID  string `xml:"pluginid"`
+	Alert     string `xml:"alert"`
+	Name      string `xml:"name"`
+	RiskCode  string `xml:"riskcode"`
+	Desc      string `xml:"desc"`
+	CWEID     string `xml:"cweid"`
+	URI       string `xml:"uri"` // older reports have a single instance in the alert
+	Param     string `xml:"param"`
+	Evidence  string `xml:"evidence"`
+	Instances []struct {
+		URI      string `xml:"uri"`
+		Method   string `xml:"method"`
+		Param    string `xml:"param"`
+		Evidence string `xml:"evidence"`
+	} `xml:"instances>instance"`
+}
+
+// zap-baseline.py's -x report
+type zapReport struct {
+	Sites []struct {
+		Name   string     `xml:"name,attr"`
+		Alerts []zapAlert `xml:"alerts>alertitem"`
+	} `xml:"site"`
+}
+
+// ZAP's riskcode is 0 to 3
+var zapRisk = map[string]string{"0": SevInfo, "1": SevLow, "2": SevMedium, "3": SevHigh}
+
+// ZAP's descriptions are HTML paragraphs
+var tagRe = regexp.MustCompile(`<[^>]+>`)
+
+// Each instance of an alert is a finding
+func parseZap(r io.Reader) ([]Finding, error) {
+	var rpt zapReport
+	if err := xml.NewDecoder(r).Decode(&rpt); err != nil {
+		return nil, err
+	}
+
+	fs := make([]Finding, 0)
+	for _, s := range rpt.Sites {
+		for _, a := range s.Alerts {
+			title := a.Alert
+			if title == "" {
+				title = a.Name
+			}
+			sev, ok := zapRisk[a.RiskCode]
+			if !ok {
+				sev = SevInfo
+			}
+			f := Finding{
+				RuleID:   a.PluginID,
+				Severity: sev,
+				Title:    title,
+				CWE:      parseCWE(a.CWEID),
+			}
+
+			if len(a.Instances) == 0 {
+				f.Location = a.URI
+				if f.Location == "" {
+					f.Location = s.Name
+				}
+				f.Evidence = zapEvidence(a.Param, a.Evidence, tagRe.ReplaceAllString(a.Desc, ""))
+				fs = append(fs, f)
+				continue
+			}
+			for _, in := range a.Instances {
+				f.Location = in.URI
+				f.Evidence = zapEvidence(in.Param, in.Evidence, "")
+				fs = append(fs, f)
+			}
+		}
+	}
+	return fs, nil
+}
+
+// What ZAP found for an instance, falling back to its description
+func zapEvidence(param string, evidence string, desc string) string {
+	switch {
+	case evidence != "" && param != "":
+		return param + ": " + evidence
+	case evidence != "":
+		return evidence
+	case param != "":
+		return "parameter " + param
+	}
+	return desc
+}
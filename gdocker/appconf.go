@@ -0,0 +1,278 @@
+// gdocker
+package gdocker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	g "github.com/appsecpipeline/gasp"
+	"gopkg.in/yaml.v2"
+)
+
+// Per-app overrides from [app-name]-pipeline.yaml and [app-name]-tool.yaml.
+// Either file is optional.
+//
+// [app-name]-pipeline.yaml has the same profiles and params sections as
+// master.yaml.  Its named pipelines are added to those in master.yaml,
+// replacing any with the same name, and its params are defaults on top of
+// master.yaml's.
+//
+// [app-name]-tool.yaml has the same format as secpipeline-config.yaml.  A
+// tool only in it is added, otherwise what it sets is merged over the tool in
+// secpipeline-config.yaml e.g. a new docker image, extra tool-profiles or
+// replaced commands.
+type appConf struct {
+	pipeFile string // base name of [app-name]-pipeline.yaml, empty if there isn't one
+	toolFile string // base name of [app-name]-tool.yaml, empty if there isn't one
+	mstr     g.M
+	mc       masterConf
+	tools    map[string]g.SecTool
+}
+
+// Read any per-app files for app from dir
+func readAppConf(dir string, app string) (*appConf, error) {
+	a := &appConf{}
+	if app == "" {
+		return a, nil
+	}
+	if strings.ContainsAny(app, `/\`) {
+		return a, fmt.Errorf("app name '%s' can't contain / or \\ as it's used to find %s-pipeline.yaml", app, app)
+	}
+
+	// Named pipelines and params for this app
+	pf := app + "-pipeline.yaml"
+	f, err := ioutil.ReadFile(path.Join(dir, pf))
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(f, &a.mstr); err != nil {
+			return a, fmt.Errorf("unable to parse %s: %v", pf, err)
+		}
+		if err := yaml.Unmarshal(f, &a.mc); err != nil {
+			return a, fmt.Errorf("unable to parse %s: %v", pf, err)
+		}
+		a.pipeFile = pf
+		infoLog.Printf("Read app pipelines from %s", path.Join(dir, pf))
+	case !os.IsNotExist(err):
+		return a, fmt.Errorf("unable to read %s: %v", pf, err)
+	}
+
+	// Tool overrides for this app
+	tf := app + "-tool.yaml"
+	f, err = ioutil.ReadFile(path.Join(dir, tf))
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(f, &a.tools); err != nil {
+			return a, fmt.Errorf("unable to parse %s: %v", tf, err)
+		}
+		a.toolFile = tf
+		infoLog.Printf("Read app tools from %s", path.Join(dir, tf))
+	case !os.IsNotExist(err):
+		return a, fmt.Errorf("unable to read %s: %v", tf, err)
+	}
+
+	return a, nil
+}
+
+// Merge the app's files over master.yaml and secpipeline-config.yaml,
+// returning what was overridden.  Anything that can't be merged e.g. a
+// parameter with a different data_type is returned as an error listing every
+// conflict.
+func (a *appConf) apply(mstr *g.M, mc *masterConf, sec *g.S) ([]string, error) {
+	notes := make([]string, 0)
+	conflicts := make([]string, 0)
+
+	// Named pipelines, with their step settings
+	if mstr.Prof == nil {
+		mstr.Prof = make(map[string]g.Profiles)
+	}
+	if mc.Prof == nil {
+		mc.Prof = make(map[string]profileConf)
+	}
+	if mc.profFrom == nil {
+		mc.profFrom = make(map[string]string)
+	}
+	profs := make([]string, 0, len(a.mstr.Prof))
+	for name := range a.mstr.Prof {
+		profs = append(profs, name)
+	}
+	sort.Strings(profs)
+	for _, name := range profs {
+		if _, ok := mstr.Prof[name]; ok {
+			notes = append(notes, fmt.Sprintf("named pipeline '%s' from %s replaces the one in master.yaml", name, a.pipeFile))
+		} else {
+			notes = append(notes, fmt.Sprintf("named pipeline '%s' is from %s", name, a.pipeFile))
+		}
+		mstr.Prof[name] = a.mstr.Prof[name]
+		mc.Prof[name] = a.mc.Prof[name]
+		mc.profFrom[name] = a.pipeFile
+	}
+
+	// Default params
+	if len(a.mc.Params) > 0 {
+		for k := range a.mc.Params {
+			if _, ok := mc.Params[k]; ok {
+				notes = append(notes, fmt.Sprintf("param %s from %s replaces the one in master.yaml", k, a.pipeFile))
+			}
+		}
+		mc.appParams = a.mc.Params
+		mc.appFile = a.pipeFile
+	}
+
+	// Tools
+	if sec.T == nil {
+		sec.T = make(map[string]g.SecTool)
+	}
+	tools := make([]string, 0, len(a.tools))
+	for name := range a.tools {
+		tools = append(tools, name)
+	}
+	sort.Strings(tools)
+	for _, name := range tools {
+		base, ok := sec.T[name]
+		if !ok {
+			notes = append(notes, fmt.Sprintf("tool %s is from %s", name, a.toolFile))
+			sec.T[name] = a.tools[name]
+			continue
+		}
+		t, n, c := mergeTool(name, base, a.tools[name], a.toolFile)
+		sec.T[name] = t
+		notes = append(notes, n...)
+		conflicts = append(conflicts, c...)
+	}
+	sort.Strings(notes)
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return notes, fmt.Errorf("%s conflicts with secpipeline-config.yaml:\n  %s", a.toolFile, strings.Join(conflicts, "\n  "))
+	}
+	return notes, nil
+}
+
+// Merge an app's settings for a tool over its settings from
+// secpipeline-config.yaml, returning the merged tool, what was overridden and
+// any conflicts
+func mergeTool(name string, base g.SecTool, app g.SecTool, file string) (g.SecTool, []string, []string) {
+	notes := make([]string, 0)
+	conflicts := make([]string, 0)
+	t := base
+
+	// Settings set in the app file replace those from secpipeline-config.yaml
+	for _, f := range []struct {
+		key  string
+		base *string
+		app  string
+	}{
+		{"docker", &t.Docker, app.Docker},
+		{"type", &t.ToolType, app.ToolType},
+		{"scan_type", &t.ScanType, app.ScanType},
+		{"version", &t.Version, app.Version},
+		{"tool-version", &t.ToolVer, app.ToolVer},
+		{"description", &t.Description, app.Description},
+		{"url", &t.Url, app.Url},
+		{"documentation", &t.Documentation, app.Documentation},
+		{"icon-sm", &t.IconSm, app.IconSm},
+		{"icon-lg", &t.IconLg, app.IconLg},
+	} {
+		if f.app != "" && f.app != *f.base {
+			notes = append(notes, fmt.Sprintf("%s: %s '%s' from %s replaces '%s'", name, f.key, f.app, file, *f.base))
+			*f.base = f.app
+		}
+	}
+	if len(app.Tags) > 0 {
+		t.Tags = app.Tags
+	}
+
+	// Parameters can be added but not redefined
+	t.Parameters = make(map[string]g.PMeta)
+	for k, v := range base.Parameters {
+		t.Parameters[k] = v
+	}
+	params := make([]string, 0, len(app.Parameters))
+	for k := range app.Parameters {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		pm := app.Parameters[k]
+		b, ok := base.Parameters[k]
+		if !ok {
+			notes = append(notes, fmt.Sprintf("%s: parameter %s is from %s", name, k, file))
+			t.Parameters[k] = pm
+			continue
+		}
+		if !strings.EqualFold(pm.PType, b.PType) || !strings.EqualFold(pm.DataType, b.DataType) {
+			conflicts = append(conflicts, fmt.Sprintf("%s: parameter %s is type %s, data_type %s in secpipeline-config.yaml but type %s, data_type %s in %s",
+				name, k, b.PType, b.DataType, pm.PType, pm.DataType, file))
+			continue
+		}
+		if pm.Desc != "" {
+			b.Desc = pm.Desc
+		}
+		t.Parameters[k] = b
+	}
+
+	// Commands and tool-profiles are merged key by key
+	t.Cmds = mergeStrings(name, "command", base.Cmds, app.Cmds, file, &notes)
+	t.Pfls = mergeStrings(name, "tool-profile", base.Pfls, app.Pfls, file, &notes)
+
+	return t, notes, conflicts
+}
+
+// Merge app over base, noting what was replaced or added
+func mergeStrings(name string, kind string, base map[string]string, app map[string]string, file string, notes *[]string) map[string]string {
+	m := make(map[string]string)
+	for k, v := range base {
+		m[k] = v
+	}
+	for _, k := range sortedKeys(app) {
+		b, ok := base[k]
+		switch {
+		case !ok:
+			*notes = append(*notes, fmt.Sprintf("%s: %s '%s' is from %s", name, kind, k, file))
+		case b != app[k]:
+			*notes = append(*notes, fmt.Sprintf("%s: %s '%s' from %s replaces the one in secpipeline-config.yaml", name, kind, k, file))
+		}
+		m[k] = app[k]
+	}
+	return m
+}
+
+// Use the tool-profile sent with --tool-profile for every step whose tool has
+// it, returning which steps were changed
+func useToolProfile(run *runInfo, profile string) ([]string, error) {
+	changed := make([]string, 0)
+	found := false
+	for _, st := range run.stages() {
+		for i := 0; i < len(st.steps); i++ {
+			s := st.steps[i]
+			if _, ok := run.toolProfiles[s.Tool].Pfls[profile]; !ok {
+				continue
+			}
+			found = true
+			if s.ToolProfile == profile {
+				continue
+			}
+			changed = append(changed, fmt.Sprintf("%s stage: %s uses tool-profile '%s' instead of '%s'", st.name, s.Tool, profile, s.ToolProfile))
+			s.ToolProfile = profile
+			st.steps[i] = s
+		}
+	}
+	if !found {
+		return changed, fmt.Errorf("no tool in the '%s' named pipeline has a '%s' tool-profile to use for --tool-profile", run.name, profile)
+	}
+	return changed, nil
+}
+
+// Keys of a map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,151 @@
+// gdocker
+package gdocker
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	g "github.com/appsecpipeline/gasp"
+)
+
+// master.yaml and secpipeline-config.yaml from testdata
+func readTestConfs(t *testing.T) (g.M, masterConf, g.S) {
+	mstr := g.M{}
+	mc, err := readMaster("testdata", "master.yaml", &mstr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec := g.S{}
+	if err := readSecPipe("testdata", "secpipeline-config.yaml", &sec); err != nil {
+		t.Fatal(err)
+	}
+	return mstr, mc, sec
+}
+
+func TestReadAppConf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gasp-docker-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "myapp-pipeline.yaml", `
+params:
+  LOC: /opt/appsecpipeline/source/app
+profiles:
+  stages:
+    pipeline:
+      - tool: "scan"
+        tool-profile: "quick"
+  nightly:
+    pipeline:
+      - tool: "extra"
+        tool-profile: "all"
+`)
+	writeTestFile(t, dir, "myapp-tool.yaml", `
+scan:
+  docker: "gasp-test/scan:2.0"
+  parameters:
+    LOC:
+      type: runtime
+      data_type: string
+      description: "Where the app is."
+    DEPTH:
+      type: runtime
+      data_type: int
+  profiles:
+    quick: "$LOC --depth $DEPTH"
+extra:
+  type: "static"
+  docker: "gasp-test/extra:1.0"
+  commands:
+    exec: "extra"
+  profiles:
+    all: ""
+`)
+
+	a, err := readAppConf(dir, "myapp")
+	if err != nil {
+		t.Fatalf("readAppConf failed: %v", err)
+	}
+	if a.pipeFile != "myapp-pipeline.yaml" || a.toolFile != "myapp-tool.yaml" {
+		t.Errorf("read %s and %s", a.pipeFile, a.toolFile)
+	}
+
+	mstr, mc, sec := readTestConfs(t)
+	notes, err := a.apply(&mstr, &mc, &sec)
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	// Named pipelines are replaced or added whole
+	if p := mstr.Prof["stages"]; len(p.Pipeline) != 1 || p.Pipeline[0].ToolProfile != "quick" || len(p.Startup) != 0 {
+		t.Errorf("stages named pipeline = %+v, want only scan quick", p)
+	}
+	if _, ok := mstr.Prof["nightly"]; !ok || mc.profFrom["nightly"] != "myapp-pipeline.yaml" {
+		t.Errorf("nightly named pipeline wasn't added from myapp-pipeline.yaml")
+	}
+	if _, ok := mstr.Prof["reports"]; !ok || mc.profFrom["reports"] != "" {
+		t.Errorf("reports named pipeline from master.yaml wasn't kept")
+	}
+	if mc.appParams["LOC"] != "/opt/appsecpipeline/source/app" || mc.appFile != "myapp-pipeline.yaml" {
+		t.Errorf("app params = %v from %s", mc.appParams, mc.appFile)
+	}
+
+	// Tools are merged over secpipeline-config.yaml's
+	scan := sec.T["scan"]
+	if scan.Docker != "gasp-test/scan:2.0" || scan.Cmds["exec"] != "scan" || scan.Pfls["all"] != "$LOC" || scan.Pfls["quick"] == "" {
+		t.Errorf("merged scan = %+v", scan)
+	}
+	if scan.Parameters["LOC"].Desc != "Where the app is." || scan.Parameters["DEPTH"].DataType != "int" {
+		t.Errorf("merged scan parameters = %+v", scan.Parameters)
+	}
+	if sec.T["extra"].Docker != "gasp-test/extra:1.0" || sec.T["lint"].Docker == "" {
+		t.Errorf("tools after apply = %+v", sec.T)
+	}
+
+	want := []string{
+		"named pipeline 'stages' from myapp-pipeline.yaml replaces the one in master.yaml",
+		"scan: docker 'gasp-test/scan:2.0' from myapp-tool.yaml replaces 'gasp-test/scan:1.0'",
+		"scan: tool-profile 'quick' is from myapp-tool.yaml",
+		"tool extra is from myapp-tool.yaml",
+	}
+	for _, w := range want {
+		if !contains(notes, w) {
+			t.Errorf("notes %q don't include %q", notes, w)
+		}
+	}
+}
+
+func TestReadAppConfErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gasp-docker-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Neither file is needed
+	if a, err := readAppConf(dir, "noapp"); err != nil || a.pipeFile != "" || a.toolFile != "" {
+		t.Errorf("readAppConf with no files = %+v, %v", a, err)
+	}
+	if _, err := readAppConf(dir, "../myapp"); err == nil {
+		t.Errorf("readAppConf with a / in the app name didn't return an error")
+	}
+
+	writeTestFile(t, dir, "broken-pipeline.yaml", "profiles: [nope\n")
+	if _, err := readAppConf(dir, "broken"); err == nil || !strings.Contains(err.Error(), "broken-pipeline.yaml") {
+		t.Errorf("readAppConf with a broken pipeline file returned %v", err)
+	}
+
+	// A parameter can't change its data_type
+	writeTestFile(t, dir, "retyped-tool.yaml", "scan:\n  parameters:\n    LOC:\n      type: runtime\n      data_type: int\n")
+	a, err := readAppConf(dir, "retyped")
+	if err != nil {
+		t.Fatalf("readAppConf failed: %v", err)
+	}
+	mstr, mc, sec := readTestConfs(t)
+	if _, err := a.apply(&mstr, &mc, &sec); err == nil || !strings.Contains(err.Error(), "scan: parameter LOC") {
+		t.Errorf("apply with a retyped parameter returned %v", err)
+	}
+}
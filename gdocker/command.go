@@ -0,0 +1,106 @@
+// gdocker
+package gdocker
+
+import (
+	"strings"
+
+	g "github.com/appsecpipeline/gasp"
+)
+
+// The commands for a tool run from secpipeline-config.yaml
+type toolCommand struct {
+	pre   string // run before the tool e.g. snyk auth, optional
+	main  string // exec + report + the tool-profile's options
+	post  string // run after the tool e.g. a report parser, optional
+	exec  bool   // the tool has an exec command, if not its image's entrypoint is the tool
+	shell bool   // the tool's shell key is true
+
+	// Secrets whose values are in a container's arguments, as neither a
+	// shell nor the image's entrypoint can expand them from the environment
+	exposed []string
+}
+
+// The full command as a shell would run it e.g. for logging
+func (tc toolCommand) String() string {
+	parts := make([]string, 0, 3)
+	for _, c := range []string{tc.pre, tc.main, tc.post} {
+		if strings.TrimSpace(c) != "" {
+			parts = append(parts, strings.TrimSpace(c))
+		}
+	}
+	return strings.Join(parts, " && ")
+}
+
+// Build the container specs to run a tool's command from base, which has the
+// tool's name, image, mounts, etc.
+//
+// When shell is true the whole command - pre && main && post - runs as one
+// sh -c script.  Otherwise pre and post run as their own containers named
+// [name]_pre and [name]_post, with the same image and volumes as the tool,
+// either side of the tool's container.
+func (tc toolCommand) specs(base ContainerSpec) ([]ContainerSpec, error) {
+	if tc.shell && tc.exec {
+		s := base
+		s.Entrypoint = "sh"
+		s.Cmd = []string{"-c", tc.String()}
+		return []ContainerSpec{s}, nil
+	}
+
+	specs := make([]ContainerSpec, 0, 3)
+	if strings.TrimSpace(tc.pre) != "" {
+		s, err := commandSpec(base, base.Name+"_pre", tc.pre)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, s)
+	}
+
+	// The tool itself uses its image's entrypoint
+	args, err := splitCommand(tc.main)
+	if err != nil {
+		return nil, err
+	}
+	s := base
+	s.Cmd = args
+	specs = append(specs, s)
+
+	if strings.TrimSpace(tc.post) != "" {
+		s, err := commandSpec(base, base.Name+"_post", tc.post)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, s)
+	}
+
+	return specs, nil
+}
+
+// A spec which runs cmd in place of the image's entrypoint
+func commandSpec(base ContainerSpec, name string, cmd string) (ContainerSpec, error) {
+	args, err := splitCommand(cmd)
+	if err != nil {
+		return ContainerSpec{}, err
+	}
+	s := base
+	s.Name = name
+	if len(args) > 0 {
+		s.Entrypoint = args[0]
+		s.Cmd = args[1:]
+	}
+	return s, nil
+}
+
+// Check if a tool's image entrypoint expands ${NAME} in its arguments from
+// the environment i.e. its secrets key is env
+func toolSecretsEnv(t g.SecTool) bool {
+	return strings.EqualFold(strings.TrimSpace(t.Cmds["secrets"]), "env")
+}
+
+// Parse a tool's shell key - yaml gives us True/False as a string
+func toolShell(t g.SecTool) bool {
+	switch strings.ToLower(strings.TrimSpace(t.Cmds["shell"])) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+// gdocker
+package gdocker
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appsecpipeline/gasp-docker/dojo"
+)
+
+// The tool in secpipeline-config.yaml whose parameters configure --dojo and
+// whose steps --dojo does in-process instead of running its container
+const dojoTool = "defectdojo"
+
+// Product type of products --dojo creates, DefectDojo's default of
+// Research and Development
+const dojoProdType = 1
+
+// dojoRun imports a run's reports into DefectDojo with its API
+type dojoRun struct {
+	client     *dojo.Client
+	product    int    // from DOJO_PRODUCT_ID, else the app's product which is created if needed
+	engagement int    // from DOJO_ENGAGEMENT_ID, else one created for the run
+	buildID    string // BUILD_ID for a created engagement
+	imported   []string
+	errs       []string
+	mu         sync.Mutex // guards imported and errs as tools finish concurrently
+}
+
+// Check if a step is done in-process by --dojo rather than in a container
+func (run *runInfo) nativeStep(s step) bool {
+	return run.dojoNative && s.Tool == dojoTool
+}
+
+// Set up the DefectDojo client from the defectdojo tool's parameters e.g.
+// DOJO_HOST, DOJO_API_KEY and DOJO_PROXY
+func newDojoRun(run *runInfo) (*dojoRun, error) {
+	params := run.sentParams[dojoTool]
+	problems := make([]string, 0)
+	for _, name := range []string{"DOJO_HOST", "DOJO_API_KEY"} {
+		if params[name] == "" {
+			problems = append(problems, name+" wasn't sent")
+		}
+	}
+	for name, val := range params {
+		pm := run.toolProfiles[dojoTool].Parameters[name]
+		if err := validateParam(pm.DataType, val); err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s): %v", name, pm.DataType, err))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid or missing parameters for --dojo:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	d := &dojoRun{buildID: params["BUILD_ID"]}
+	d.product, _ = strconv.Atoi(params["DOJO_PRODUCT_ID"])
+	d.engagement, _ = strconv.Atoi(params["DOJO_ENGAGEMENT_ID"])
+	c, err := dojo.NewClient(params["DOJO_HOST"], params["DOJO_API_KEY"], params["DOJO_PROXY"])
+	if err != nil {
+		return nil, fmt.Errorf("unable to set up --dojo: %v", err)
+	}
+	d.client = c
+
+	return d, nil
+}
+
+// Find or create the product and engagement the run's reports are imported
+// into, before any tools are run
+func (d *dojoRun) setup(ctx context.Context, run *runInfo) error {
+	if run.dryRun {
+		fmt.Printf("DRY RUN - not connecting to DefectDojo at %s\n", d.client.Host)
+		return nil
+	}
+
+	// An existing engagement decides the product if DOJO_PRODUCT_ID wasn't sent
+	if d.engagement > 0 {
+		e, err := d.client.Engagement(ctx, d.engagement)
+		if err != nil {
+			return fmt.Errorf("unable to get DefectDojo engagement %d: %v", d.engagement, err)
+		}
+		if d.product > 0 && e.Product != d.product {
+			return fmt.Errorf("DefectDojo engagement %d is for product %d, not DOJO_PRODUCT_ID %d", d.engagement, e.Product, d.product)
+		}
+		d.product = e.Product
+		infoLog.Printf("Using DefectDojo engagement %d of product %d", d.engagement, d.product)
+		fmt.Printf("Importing reports into DefectDojo engagement %d of product %d\n", d.engagement, d.product)
+		return nil
+	}
+
+	// Reuse or create the app's product
+	if d.product > 0 {
+		if _, err := d.client.Product(ctx, d.product); err != nil {
+			return fmt.Errorf("unable to get DefectDojo product %d: %v", d.product, err)
+		}
+	} else {
+		p, err := d.client.ProductByName(ctx, run.appName)
+		if err != nil {
+			return fmt.Errorf("unable to find the DefectDojo product for %s: %v", run.appName, err)
+		}
+		if p == nil {
+			p, err = d.client.CreateProduct(ctx, dojo.Product{
+				Name:        run.appName,
+				Description: "Created by gasp-docker for " + run.appName,
+				ProdType:    dojoProdType,
+			})
+			if err != nil {
+				return fmt.Errorf("unable to create a DefectDojo product for %s: %v", run.appName, err)
+			}
+			infoLog.Printf("Created DefectDojo product %d for %s", p.ID, run.appName)
+		}
+		d.product = p.ID
+	}
+
+	// A new engagement for this run
+	today := time.Now().Format("2006-01-02")
+	e, err := d.client.CreateEngagement(ctx, dojo.Engagement{
+		Name:           fmt.Sprintf("gasp-docker %s %s", run.name, run.runId),
+		Description:    fmt.Sprintf("gasp-docker run %s of the %s named pipeline for %s", run.runId, run.name, run.appName),
+		Product:        d.product,
+		TargetStart:    today,
+		TargetEnd:      today,
+		EngagementType: "CI/CD",
+		Status:         "In Progress",
+		BuildID:        d.buildID,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create a DefectDojo engagement for product %d: %v", d.product, err)
+	}
+	d.engagement = e.ID
+	infoLog.Printf("Created DefectDojo engagement %d of product %d", d.engagement, d.product)
+	fmt.Printf("Importing reports into DefectDojo engagement %d of product %d\n", d.engagement, d.product)
+
+	return nil
+}
+
+// DefectDojo's minimum_severity for a gasp-docker severity e.g. high is High
+func dojoSeverity(sev string) string {
+	return strings.ToUpper(sev[:1]) + sev[1:]
+}
+
+// Import a step's report into the run's engagement once the step has
+// finished, for tools DefectDojo has a scan type for.  ctx is the run's so
+// nothing is imported once it's cancelled.
+func dojoImport(ctx context.Context, r *stepResult, run *runInfo) {
+	d := run.dojo
+	if d == nil || d.engagement == 0 || r.report == "" || run.dryRun {
+		return
+	}
+	if r.status == statusCancelled || r.status == statusSkipped {
+		return
+	}
+	if ctx.Err() != nil {
+		infoLog.Printf("Run cancelled, not importing %s for %s into DefectDojo", r.report, r.tool.Tool)
+		return
+	}
+	st, ok := dojo.ScanType(r.tool.Tool)
+	if !ok {
+		infoLog.Printf("DefectDojo has no scan type for %s, not importing %s", r.tool.Tool, r.report)
+		return
+	}
+
+	name, data, err := findingsData(r, run)
+	if err == nil {
+		_, err = d.client.ImportScan(ctx, dojo.Scan{
+			Engagement:  d.engagement,
+			ScanType:    st,
+			FileName:    path.Base(name),
+			Data:        data,
+			MinSeverity: dojoSeverity(r.tool.minSeverity(run.global)),
+			Tags:        []string{"gasp-docker", r.tool.Tool},
+		})
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		warnLog.Printf("Unable to import %s for %s into DefectDojo, error was: %s", name, r.tool.Tool, err)
+		fmt.Fprintf(&r.log.console, "Unable to import %s for %s into DefectDojo: %s\n", name, r.tool.Tool, err)
+		d.errs = append(d.errs, fmt.Sprintf("%s: %v", r.tool.Tool, err))
+		return
+	}
+	infoLog.Printf("Imported %s for %s into DefectDojo engagement %d as %s", name, r.tool.Tool, d.engagement, st)
+	fmt.Fprintf(&r.log.console, "Imported %s into DefectDojo as a %s\n", name, st)
+	d.imported = append(d.imported, fmt.Sprintf("%s (%s)", r.tool.Tool, st))
+}
+
+// Close the run's engagement once the final stage is done
+func (d *dojoRun) close(ctx context.Context, run *runInfo) {
+	if run.dryRun || d.engagement == 0 {
+		return
+	}
+	if err := d.client.CloseEngagement(ctx, d.engagement); err != nil {
+		warnLog.Printf("Unable to close DefectDojo engagement %d, error was: %s", d.engagement, err)
+		d.errs = append(d.errs, fmt.Sprintf("closing engagement %d: %v", d.engagement, err))
+		return
+	}
+	infoLog.Printf("Closed DefectDojo engagement %d", d.engagement)
+}
+
+// Print what was imported into DefectDojo
+func (d *dojoRun) summary() {
+	if d.engagement == 0 {
+		return
+	}
+	fmt.Printf("DefectDojo engagement %d: imported %d report(s)\n", d.engagement, len(d.imported))
+	for _, i := range d.imported {
+		fmt.Printf("  %s\n", i)
+	}
+	for _, e := range d.errs {
+		fmt.Printf("  ERROR: %s\n", e)
+	}
+}
@@ -0,0 +1,69 @@
+// gdocker
+package gdocker
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	g "github.com/appsecpipeline/gasp"
+	"github.com/appsecpipeline/gasp-docker/dojo"
+)
+
+func TestDojoImport(t *testing.T) {
+	var mu sync.Mutex
+	imports := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/api/v2/import-scan/" {
+			imports++
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"test": 88, "engagement": 40, "scan_type": "Bandit Scan"}`))
+	}))
+	defer srv.Close()
+	c, err := dojo.NewClient(srv.URL, "s3cret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The report is read from a local --volume directory
+	vol, err := ioutil.TempDir("", "gasp-docker-vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(vol)
+	if err := os.MkdirAll(filepath.Join(vol, "reports"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(vol, "reports", "1234.json"), []byte(`{"results": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := &runInfo{Vol: vol, dojo: &dojoRun{client: c, engagement: 40}}
+	r := &stepResult{
+		stage:  "pipeline",
+		tool:   step{Tools: g.Tools{Tool: "bandit", ToolProfile: "tuned"}},
+		status: statusPassed,
+		report: reportPath("1234.json"),
+	}
+
+	// Nothing is imported once the run's cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dojoImport(ctx, r, run)
+	if imports != 0 || len(run.dojo.imported) != 0 || len(run.dojo.errs) != 0 {
+		t.Errorf("cancelled run imported %d reports, errors %v", imports, run.dojo.errs)
+	}
+
+	dojoImport(context.Background(), r, run)
+	if imports != 1 || len(run.dojo.imported) != 1 || run.dojo.imported[0] != "bandit (Bandit Scan)" {
+		t.Errorf("imported %d reports %v, errors %v, want bandit", imports, run.dojo.imported, run.dojo.errs)
+	}
+}
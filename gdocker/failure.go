@@ -0,0 +1,124 @@
+// gdocker
+package gdocker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// on-failure policies for a step in master.yaml
+const (
+	policyFail      = "fail"       // stop the run, the default
+	policyContinue  = "continue"   // record the failure and run the next step
+	policySkipStage = "skip-stage" // record the failure and skip the rest of the stage
+	policyRetry     = "retry"      // retry:N - rerun the step up to N more times, then fail
+)
+
+// Status of a step once its stage has run
+const (
+	statusPassed    = "passed"
+	statusFailed    = "failed"    // failed and failed the run
+	statusTolerated = "tolerated" // failed but its on-failure policy allowed the run to go on
+	statusSkipped   = "skipped"   // never run because of an earlier failure or the run being cancelled
+	statusCancelled = "cancelled" // stopped part way through when the run was cancelled
+)
+
+// Exit codes for a gasp-docker run
+const (
+	ExitSuccess   = 0   // every step passed
+	ExitFailed    = 1   // a step failed the run or the run couldn't start
+	ExitTolerated = 2   // the run completed but some steps failed and were tolerated
+	ExitGate      = 3   // the run completed but its findings failed a quality gate
+	ExitCancelled = 130 // the run was cancelled e.g. by Ctrl-C or SIGTERM
+)
+
+// failurePolicy is a parsed on-failure setting
+type failurePolicy struct {
+	action  string
+	retries int
+}
+
+// Parse an on-failure value from master.yaml, empty means fail
+func parsePolicy(p string) (failurePolicy, error) {
+	p = strings.TrimSpace(p)
+	switch p {
+	case "", policyFail:
+		return failurePolicy{action: policyFail}, nil
+	case policyContinue, policySkipStage:
+		return failurePolicy{action: p}, nil
+	}
+
+	if strings.HasPrefix(p, policyRetry+":") {
+		n, err := strconv.Atoi(strings.TrimPrefix(p, policyRetry+":"))
+		if err != nil || n < 1 {
+			return failurePolicy{}, fmt.Errorf("retry needs a positive number of retries e.g. retry:2, got '%s'", p)
+		}
+		return failurePolicy{action: policyRetry, retries: n}, nil
+	}
+
+	return failurePolicy{}, fmt.Errorf("unknown on-failure '%s', expected fail, continue, skip-stage or retry:N", p)
+}
+
+// Mark the run as failed so no further steps are started
+func (run *runInfo) setFailed() {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.failed = true
+}
+
+// Check if a step has failed the run
+func (run *runInfo) isFailed() bool {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return run.failed
+}
+
+// Exit code for a run based on the status of its steps
+func (run *runInfo) exitCode() int {
+	if run.cancelled {
+		return ExitCancelled
+	}
+	code := ExitSuccess
+	for _, r := range run.results {
+		switch r.status {
+		case statusFailed:
+			return ExitFailed
+		case statusTolerated:
+			code = ExitTolerated
+		}
+	}
+	// Too many findings fails the build even if the failed steps were tolerated
+	if !run.gate().Passed() {
+		return ExitGate
+	}
+	return code
+}
+
+// Print and log the status of every step in the run
+func (run *runInfo) summary() {
+	fmt.Printf("\nRun %s of the %s named pipeline\n", run.runId, run.name)
+	fmt.Printf("  %-10s %-20s %-20s %-10s %s\n", "STAGE", "TOOL", "TOOL-PROFILE", "STATUS", "ATTEMPTS")
+	for _, r := range run.results {
+		fmt.Printf("  %-10s %-20s %-20s %-10s %d\n", r.stage, r.tool.Tool, r.tool.ToolProfile, r.status, r.attempts)
+		if r.err != nil {
+			fmt.Printf("  %-10s   => %s\n", "", run.mask(r.err.Error()))
+		}
+		infoLog.Print(run.mask(fmt.Sprintf("Step %s/%s (%s) finished as %s after %d attempt(s), error: %v\n",
+			r.stage, r.tool.Tool, r.tool.ToolProfile, r.status, r.attempts, r.err)))
+	}
+	if len(run.removed) > 0 {
+		fmt.Println("Cleaned up:")
+		for _, rm := range run.removed {
+			fmt.Printf("  %s\n", rm)
+		}
+	}
+	if run.cancelled {
+		fmt.Println("Run was cancelled before it completed")
+	}
+	run.gateSummary()
+	if run.dojo != nil {
+		run.dojo.summary()
+	}
+	fmt.Printf("Run exit code: %d\n\n", run.exitCode())
+}
@@ -0,0 +1,246 @@
+// gdocker
+package gdocker
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	g "github.com/appsecpipeline/gasp"
+	"github.com/appsecpipeline/gasp-docker/findings"
+)
+
+// Quality gate limits from master.yaml's global section.  gasp's Gconf
+// can't tell a max that isn't set from max: 0 so they're read again here.
+type gateConf struct {
+	MaxCritical *int `yaml:"max-critical"`
+	MaxHigh     *int `yaml:"max-high"`
+	MaxMedium   *int `yaml:"max-medium"`
+
+	// fail (the default) or warn when a tool's findings couldn't be read
+	// from its report
+	Unread string `yaml:"gate-unread"`
+}
+
+// Severities in the order they're gated and shown, most severe first
+var gateSevs = []string{findings.SevCritical, findings.SevHigh, findings.SevMedium, findings.SevLow, findings.SevInfo}
+
+// GateResult is how a run's findings measured up to the max-critical,
+// max-high and max-medium quality gates in master.yaml
+type GateResult struct {
+	Counts map[string]int // findings at or above their step's min-severity, keyed by severity
+	Max    map[string]int // the limits set in master.yaml, keyed by severity
+	Failed []string       // severities with more findings than their max, most severe first
+	Unread []string       // tools whose findings couldn't be read from their report
+	Warn   bool           // gate-unread is warn so Unread doesn't fail the gates
+}
+
+// Passed returns true if no severity had more findings than its max and
+// every tool's findings could be read, unless gate-unread is warn
+func (gr GateResult) Passed() bool {
+	return len(gr.Failed) == 0 && (len(gr.Unread) == 0 || gr.Warn)
+}
+
+// The limits set in master.yaml keyed by severity, a severity with no max
+// is never gated
+func gateLimits(gc gateConf) (map[string]int, error) {
+	max := make(map[string]int)
+	for sev, m := range map[string]*int{
+		findings.SevCritical: gc.MaxCritical,
+		findings.SevHigh:     gc.MaxHigh,
+		findings.SevMedium:   gc.MaxMedium,
+	} {
+		if m == nil {
+			continue
+		}
+		if *m < 0 {
+			return nil, fmt.Errorf("max-%s in master.yaml can't be negative, got %d", sev, *m)
+		}
+		max[sev] = *m
+	}
+	return max, nil
+}
+
+// Check if gate-unread in master.yaml is warn, anything but fail or warn
+// is an error
+func gateWarnUnread(gc gateConf) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(gc.Unread)) {
+	case "", "fail":
+		return false, nil
+	case "warn":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown gate-unread '%s' in master.yaml, expected fail or warn", gc.Unread)
+}
+
+// Check the global and every step's min-severity is a severity gasp-docker
+// knows about
+func verifySeverities(run *runInfo) error {
+	if run.global.MinSev != "" && !findings.Known(run.global.MinSev) {
+		return fmt.Errorf("unknown min-severity '%s' in master.yaml's global section, expected info, low, medium, high or critical", run.global.MinSev)
+	}
+	for _, st := range run.stages() {
+		for i := 0; i < len(st.steps); i++ {
+			s := st.steps[i]
+			if s.MinSev != "" && !findings.Known(s.MinSev) {
+				warnLog.Printf("The min-severity for '%v' in the %v stage is invalid: %s", s.Tool, st.name, s.MinSev)
+				return fmt.Errorf("unknown min-severity '%s' for %v in the %v stage of master.yaml, expected info, low, medium, high or critical", s.MinSev, s.Tool, st.name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// The lowest severity of a step's findings which counts towards the quality
+// gates - its own min-severity, else the global one, else everything
+func (s step) minSeverity(global g.Gconf) string {
+	switch {
+	case s.MinSev != "":
+		return findings.Severity(s.MinSev)
+	case global.MinSev != "":
+		return findings.Severity(global.MinSev)
+	}
+	return findings.SevInfo
+}
+
+// The file a tool's findings are read from.  arachni's report is binary and
+// its post command converts it to [reportname].json.
+func findingsReport(tool string, report string) string {
+	if tool == "arachni" {
+		return report + ".json"
+	}
+	return report
+}
+
+// Read the file a step's findings are in, using the copy collected into
+// --reports if there is one
+func findingsData(r *stepResult, run *runInfo) (string, []byte, error) {
+	name := findingsReport(r.tool.Tool, r.report)
+	if e := r.collected; e != nil && e.Error == "" && name == r.report {
+		data, err := ioutil.ReadFile(filepath.Join(run.reportsDir(), filepath.FromSlash(e.Path)))
+		return name, data, err
+	}
+	data, err := readReport(name, r, run)
+	return name, data, err
+}
+
+// Read the findings from a step's report for tools the findings package can
+// parse
+func readFindings(r *stepResult, run *runInfo) {
+	if r.report == "" || run.dryRun || !findings.Supported(r.tool.Tool) {
+		return
+	}
+	if r.status == statusCancelled || r.status == statusSkipped {
+		return
+	}
+
+	name, data, err := findingsData(r, run)
+	if err == nil {
+		r.found, err = findings.Parse(r.tool.Tool, bytes.NewReader(data))
+	}
+	if err != nil {
+		r.findingsErr = err
+		warnLog.Printf("Unable to read findings from %s for %s, error was: %s", name, r.tool.Tool, err)
+		fmt.Fprintf(&r.log.console, "Unable to read findings from %s for %s: %s\n", name, r.tool.Tool, err)
+		return
+	}
+
+	c := findings.Count(r.found)
+	infoLog.Printf("Read %d findings from %s for %s: %v", len(r.found), name, r.tool.Tool, c)
+	fmt.Fprintf(&r.log.console, "%s reported %d findings - %d critical, %d high, %d medium, %d low, %d info\n", r.tool.Tool,
+		len(r.found), c[findings.SevCritical], c[findings.SevHigh], c[findings.SevMedium], c[findings.SevLow], c[findings.SevInfo])
+}
+
+// Check if any step in the run had a report findings were read from, or
+// tried to be
+func (run *runInfo) gated() bool {
+	for _, r := range run.results {
+		if r.found != nil || r.findingsErr != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Count the run's findings at or above each step's min-severity and check
+// them against master.yaml's limits
+func (run *runInfo) gate() GateResult {
+	gr := GateResult{Counts: make(map[string]int), Max: run.gateMax, Failed: make([]string, 0), Unread: make([]string, 0), Warn: run.gateWarn}
+	for _, sev := range gateSevs {
+		gr.Counts[sev] = 0
+	}
+
+	for _, r := range run.results {
+		// A failed or tolerated tool may not have written its report, that's
+		// already in its step's status so it isn't unread findings as well
+		if r.findingsErr != nil && r.status == statusPassed {
+			gr.Unread = append(gr.Unread, r.tool.Tool)
+		}
+		min := r.tool.minSeverity(run.global)
+		for _, f := range r.found {
+			if findings.AtLeast(f.Severity, min) {
+				gr.Counts[findings.Severity(f.Severity)]++
+			}
+		}
+	}
+
+	for _, sev := range gateSevs {
+		if max, ok := gr.Max[sev]; ok && gr.Counts[sev] > max {
+			gr.Failed = append(gr.Failed, sev)
+		}
+	}
+	return gr
+}
+
+// Print and log the quality gate table for a run
+func (run *runInfo) gateSummary() {
+	if !run.gated() {
+		return
+	}
+	gr := run.gate()
+
+	fmt.Println("Quality gates:")
+	fmt.Printf("  %-10s %-8s %-8s %s\n", "SEVERITY", "FOUND", "MAX", "RESULT")
+	for _, sev := range gateSevs {
+		max, res := "-", "-"
+		if m, ok := gr.Max[sev]; ok {
+			max, res = fmt.Sprintf("%d", m), "passed"
+			if gr.Counts[sev] > m {
+				res = "failed"
+			}
+		}
+		fmt.Printf("  %-10s %-8d %-8s %s\n", sev, gr.Counts[sev], max, res)
+	}
+	// Tools whose findings couldn't be read could be hiding any number of them
+	max, res := "0", "passed"
+	if gr.Warn {
+		max, res = "-", "-"
+	}
+	if len(gr.Unread) > 0 {
+		res = "failed (" + strings.Join(gr.Unread, ", ") + ")"
+		if gr.Warn {
+			res = "warning (" + strings.Join(gr.Unread, ", ") + ")"
+		}
+	}
+	fmt.Printf("  %-10s %-8d %-8s %s\n", "unread", len(gr.Unread), max, res)
+
+	if gr.Passed() {
+		infoLog.Printf("Quality gates passed with %v findings, limits were %v", gr.Counts, gr.Max)
+		if len(gr.Unread) > 0 {
+			warnLog.Printf("Findings couldn't be read for %v, gate-unread is warn", gr.Unread)
+		}
+		return
+	}
+	failed := make([]string, 0, len(gr.Failed)+1)
+	if len(gr.Failed) > 0 {
+		failed = append(failed, strings.Join(gr.Failed, ", ")+" findings")
+	}
+	if len(gr.Unread) > 0 && !gr.Warn {
+		failed = append(failed, "unread findings from "+strings.Join(gr.Unread, ", "))
+	}
+	warnLog.Printf("Quality gates failed for %s with %v findings, limits were %v", strings.Join(failed, " and "), gr.Counts, gr.Max)
+	fmt.Printf("Quality gates failed for %s\n", strings.Join(failed, " and "))
+}
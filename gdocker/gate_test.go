@@ -0,0 +1,92 @@
+// gdocker
+package gdocker
+
+import (
+	"errors"
+	"testing"
+
+	g "github.com/appsecpipeline/gasp"
+	"github.com/appsecpipeline/gasp-docker/findings"
+)
+
+// A run whose bandit step passed with the findings given and zap's report
+// couldn't be read
+func gateTestRun(found []findings.Finding) *runInfo {
+	return &runInfo{
+		gateMax: map[string]int{findings.SevHigh: 1},
+		results: []*stepResult{
+			{stage: "pipeline", tool: step{Tools: g.Tools{Tool: "bandit"}}, status: statusPassed, found: found},
+			{stage: "pipeline", tool: step{Tools: g.Tools{Tool: "zap"}}, status: statusPassed, findingsErr: errors.New("the report is empty")},
+		},
+	}
+}
+
+func TestGateUnread(t *testing.T) {
+	high := []findings.Finding{{Tool: "bandit", Severity: findings.SevHigh}}
+
+	// Findings that couldn't be read fail the gates even under every max
+	run := gateTestRun(high)
+	gr := run.gate()
+	if gr.Passed() || len(gr.Failed) != 0 || len(gr.Unread) != 1 || gr.Unread[0] != "zap" {
+		t.Errorf("gate = %+v, want failed with zap unread", gr)
+	}
+	if code := run.exitCode(); code != ExitGate {
+		t.Errorf("exitCode = %d, want %d", code, ExitGate)
+	}
+
+	// Unless gate-unread is warn
+	run.gateWarn = true
+	if gr := run.gate(); !gr.Passed() {
+		t.Errorf("gate with gate-unread warn = %+v, want passed", gr)
+	}
+	if code := run.exitCode(); code != ExitSuccess {
+		t.Errorf("exitCode with gate-unread warn = %d, want %d", code, ExitSuccess)
+	}
+
+	// which still fails on too many findings
+	run = gateTestRun(append(high, high...))
+	run.gateWarn = true
+	if gr := run.gate(); gr.Passed() || len(gr.Failed) != 1 || gr.Failed[0] != findings.SevHigh {
+		t.Errorf("gate = %+v, want failed for high", gr)
+	}
+}
+
+func TestGateToleratedUnread(t *testing.T) {
+	// zap crashed before writing its report with on-failure: continue
+	run := gateTestRun(nil)
+	run.results[1].status = statusTolerated
+	if gr := run.gate(); !gr.Passed() || len(gr.Unread) != 0 {
+		t.Errorf("gate = %+v, want passed with nothing unread", gr)
+	}
+	if code := run.exitCode(); code != ExitTolerated {
+		t.Errorf("exitCode = %d, want %d", code, ExitTolerated)
+	}
+
+	// and likewise for a failed step, which fails the run rather than the gates
+	run.results[1].status = statusFailed
+	if gr := run.gate(); !gr.Passed() || len(gr.Unread) != 0 {
+		t.Errorf("gate = %+v, want passed with nothing unread", gr)
+	}
+	if code := run.exitCode(); code != ExitFailed {
+		t.Errorf("exitCode = %d, want %d", code, ExitFailed)
+	}
+}
+
+func TestGateWarnUnread(t *testing.T) {
+	tests := []struct {
+		in   string
+		warn bool
+		err  bool
+	}{
+		{"", false, false},
+		{"fail", false, false},
+		{"Warn", true, false},
+		{"ignore", false, true},
+	}
+	for _, tt := range tests {
+		warn, err := gateWarnUnread(gateConf{Unread: tt.in})
+		if warn != tt.warn || (err != nil) != tt.err {
+			t.Errorf("gateWarnUnread(%q) = %v, %v", tt.in, warn, err)
+		}
+	}
+}
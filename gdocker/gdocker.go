@@ -2,16 +2,16 @@
 package gdocker
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	g "github.com/appsecpipeline/gasp"
@@ -24,29 +24,28 @@ import (
 // Implementation of the Images interface from Gasp
 type LocalDockers struct {
 	images []Image
+	rt     Runtime
 }
 
-type Image struct {
-	fullName string
-	name     string
-	tag      string
-	id       string
-	created  string
-	size     string
-}
-
-func (ldock *LocalDockers) SyncImages(sec *g.S) {
+func (ldock *LocalDockers) SyncImages(sec *g.S) error {
 	infoLog.Println("Ensuring needed tool images are available in the container repo")
 
 	// Get a list of images available in the repo
-	repoImages := listImages(ldock)
+	repoImages, err := listImages(ldock)
+	if err != nil {
+		return err
+	}
 
 	// Diff needed images against available ones
 	missing := diffImages(repoImages, sec)
 
 	// Pull any needed images so all tools containers are available
-	pullImages(missing)
+	if err := pullImages(ldock.rt, missing); err != nil {
+		return err
+	}
 	infoLog.Println("All needed tool images are available in the container repo")
+
+	return nil
 }
 
 // Implementation of the Event interface from gasp
@@ -86,7 +85,7 @@ func (levent *LocalEvent) GetId() string {
 	return u.String()
 }
 
-func (levent *LocalEvent) Startup(run *runInfo) {
+func (levent *LocalEvent) Startup(ctx context.Context, run *runInfo) error {
 	// Handle any defined startup tool runs for this named pipeline
 	infoLog.Printf("In Startup stage of %v run...", run.name)
 
@@ -94,128 +93,202 @@ func (levent *LocalEvent) Startup(run *runInfo) {
 	// If there's no run.Vol, then create the ephemeral data volume
 	if run.Vol == "none" {
 		// Create the data volume
-		dataVolume(run)
+		if err := dataVolume(ctx, run); err != nil {
+			return err
+		}
+	} else {
+		// Tools are run from the reports directory so it needs to exist
+		rd := path.Join(run.Vol, strings.TrimPrefix(reportDir, "/opt/appsecpipeline/"))
+		if err := os.MkdirAll(rd, 0755); err != nil {
+			errorLog.Printf("Unable to create the reports directory %s, error was: %s", rd, err)
+			return fmt.Errorf("unable to create the reports directory %s: %v", rd, err)
+		}
 	}
 
 	// Interate over the defined startup steps, running them in order
-	for i := 0; i < len(run.startup); i++ {
-		infoLog.Printf("Launching container for %v", run.startup[i].Tool)
-		err := launchContainer(run.startup[i], run)
-		if err != nil {
-			warnLog.Printf("Error launching container during startup stage of run %s", run.name)
-			errorLog.Printf("Error launching container was: %s", err)
-			errorLog.Println("Unable to launch container for this run, quitting")
-			os.Exit(1)
-		}
+	runSteps(ctx, "startup", run.startup, run, 1, 0)
+	if run.isFailed() {
+		warnLog.Printf("A step failed during startup stage of run %s, skipping the rest of the run", run.name)
 	}
 
+	return nil
 }
 
-func (levent *LocalEvent) Pipeline(run *runInfo) {
+func (levent *LocalEvent) Pipeline(ctx context.Context, run *runInfo) {
 	// Handle any defined pipeline tool runs for this named pipeline
 	infoLog.Printf("In Pipeline stage of %v run...", run.name)
 	fmt.Println("In Pipeline stage of ")
 
-	// Interate over the defined pipeline steps, running them in order
-	for i := 0; i < len(run.pipeline); i++ {
-		infoLog.Printf("Launching container for %v", run.pipeline[i].Tool)
-		err := launchContainer(run.pipeline[i], run)
-		if err != nil {
-			warnLog.Printf("Error launching container during pipeline stage of run %s", run.name)
-			errorLog.Printf("Error launching container was: %s", err)
-			errorLog.Println("Unable to launch container for this run, quitting")
-			os.Exit(1)
-		}
+	// Run the defined pipeline steps concurrently up to max-parallel (and
+	// max-dynamic for dynamic tools) from master.yaml, logging them in order
+	infoLog.Printf("Running up to %d pipeline tools at once, %d dynamic", run.global.MaxParallel, run.global.MaxDynamic)
+	runSteps(ctx, "pipeline", run.pipeline, run, run.global.MaxParallel, run.global.MaxDynamic)
+	if run.isFailed() {
+		warnLog.Printf("A step failed during pipeline stage of run %s, skipping the rest of the run", run.name)
 	}
 }
 
-func (levent *LocalEvent) Final(run *runInfo) {
+func (levent *LocalEvent) Final(ctx context.Context, run *runInfo) {
 	// Handle any defined final tool runs for this named pipeline
 	infoLog.Printf("In Final stage of %v run...", run.name)
 	fmt.Printf("In Final stage of %v\n run ", run.name)
 
-	// Interate over the defined pipeline steps, running them in order
-	for i := 0; i < len(run.final); i++ {
-		infoLog.Printf("Launching container for %v", run.final[i].Tool)
-		err := launchContainer(run.final[i], run)
-		if err != nil {
-			warnLog.Printf("Error launching container during final stage of run %s", run.name)
-			errorLog.Printf("Error launching container was: %s", err)
-			errorLog.Println("Unable to launch container for this run, quitting")
-			os.Exit(1)
-		}
+	// Interate over the defined final steps, running them in order
+	runSteps(ctx, "final", run.final, run, 1, 0)
+	if run.isFailed() {
+		warnLog.Printf("A step failed during final stage of run %s", run.name)
 	}
 }
 
 func (levent *LocalEvent) Cleanup(run *runInfo) {
-	fmt.Println("In Cleanup function")
-	// Not needed for local docker runs using --rm command-line option
+	// Remove what this run left behind - containers kept with --keep, the
+	// set-perms_ helper container and the ephemeral data volume unless
+	// --keep or --keep-volume was used
+	infoLog.Printf("In Cleanup stage of %v run...", run.name)
+	fmt.Println("In Cleanup stage")
+
+	// Containers go first since a volume can't be removed while a container uses it
+	for _, c := range run.runContainer {
+		if err := run.rt.RemoveContainer(c); err != nil {
+			warnLog.Printf("Unable to remove container %s, error was: %s", c, err)
+			fmt.Printf("Unable to remove container %s, remove it with:\n  %s rm %s\n", c, run.runtimeBin(), c)
+			continue
+		}
+		infoLog.Printf("Removed container %s", c)
+		run.removed = append(run.removed, "container "+c)
+	}
+
+	// The set-perms_ container is run with --rm so it's normally gone already
+	if run.dataVol != "" && !run.dryRun {
+		sp := "set-perms_" + run.runId
+		if err := run.rt.RemoveContainer(sp); err == nil {
+			infoLog.Printf("Removed container %s", sp)
+			run.removed = append(run.removed, "container "+sp)
+		}
+	}
+
+	for _, v := range run.runVolume {
+		// Export the volume before it's removed if asked
+		if run.exportVolume != "" {
+			if err := exportVolume(v, run.exportVolume, run); err != nil {
+				warnLog.Printf("Unable to export data volume %s to %s, error was: %s", v, run.exportVolume, err)
+				fmt.Printf("Unable to export data volume %s to %s, keeping it: %s\n", v, run.exportVolume, err)
+				continue
+			}
+			run.removed = append(run.removed, "exported volume "+v+" to "+run.exportVolume)
+		}
+
+		if run.keepVolume || run.keep {
+			infoLog.Printf("Keeping data volume %s since --keep or --keep-volume was used", v)
+			fmt.Printf("Keeping data volume %s, remove it with:\n  %s volume rm %s\n", v, run.runtimeBin(), v)
+			continue
+		}
+		if err := run.rt.RemoveVolume(v); err != nil {
+			warnLog.Printf("Unable to remove data volume %s, error was: %s", v, err)
+			fmt.Printf("Unable to remove data volume %s, remove it with:\n  %s volume rm %s\n", v, run.runtimeBin(), v)
+			continue
+		}
+		infoLog.Printf("Removed data volume %s", v)
+		run.removed = append(run.removed, "volume "+v)
+	}
+}
+
+// The command-line client for removing what a run left behind by hand -
+// the docker or podman cli, docker for the Docker Engine API
+func (run *runInfo) runtimeBin() string {
+	switch rt := run.rt.(type) {
+	case *CLIRuntime:
+		return rt.bin()
+	case *PodmanRuntime:
+		return rt.bin()
+	}
+	return "docker"
 }
 
 // Vars and functions for gasp-docker
 var logDir string = "./logs"
-var traceLog *log.Logger
-var infoLog *log.Logger
-var warnLog *log.Logger
-var errorLog *log.Logger
+
+// Where the source code is inside the containers if --location isn't set
+const defaultLoc = "/opt/appsecpipeline/source"
+
+// Image used for helper containers e.g. setting volume permissions
+const helperImage = "mtesauro/gasp-base:1.0.0"
+
+// Loggers default to discarding output until a Runner sets up logging
+var traceLog = log.New(ioutil.Discard, "", 0)
+var infoLog = log.New(ioutil.Discard, "", 0)
+var warnLog = log.New(ioutil.Discard, "", 0)
+var errorLog = log.New(ioutil.Discard, "", 0)
 
 type runInfo struct {
 	name         string
-	startup      map[int]g.Tools
-	pipeline     map[int]g.Tools
-	final        map[int]g.Tools
-	runevery     map[int]g.Tools
+	appName      string
+	startup      map[int]step
+	pipeline     map[int]step
+	final        map[int]step
+	runevery     map[int]step
 	toolProfiles map[string]g.SecTool
-	sentParams   map[string]string
+	global       g.Gconf                      // global settings from master.yaml
+	sentParams   map[string]map[string]string // parameters for each tool, keyed by tool then parameter name
 	runId        string
-	detailed     *os.File // detailed logging
-	dataVol      string   // The name of the ephemeral data volume used
-	Vol          string   // The path of the local file system to use for /opt/appsecpipeline
-	Src          string   // The path of the local file system to use for /opt/appsecpipeline/source
-	Rpt          string   // The path of the local file system to use for /opt/appsecpipeline/reports
-	runContainer []string // slice of containers run/launched in this run
-	runVolume    []string // slice of volumes run/launced in this run
+	detailed     io.Writer // detailed logging
+	dataVol      string    // The name of the ephemeral data volume used
+	Vol          string    // The path of the local file system to use for /opt/appsecpipeline
+	Src          string    // The path of the local file system to mount at loc
+	loc          string    // The path of the source code inside the containers from --location
+	Rpt          string    // The path of the local file system to collect reports into
+	runContainer []string  // slice of containers run/launched in this run
+	runVolume    []string  // slice of volumes run/launced in this run
+	rt           Runtime   // container runtime used for this run
 	keep         bool
+	keepVolume   bool              // don't remove the data volume in the cleanup stage
+	exportVolume string            // path of a tarball to export the data volume to before it's removed
+	paramsFile   string            // YAML, JSON or .env file of tool parameters
+	secretsFile  string            // NAME=value file of secrets for config parameters
+	sarifFile    string            // file to write the run's findings to as SARIF
+	junitFile    string            // file to write the run's results to as JUnit XML
+	junitAll     bool              // add a JUnit testcase for every tool's findings, not just those with a junit command
+	dojoNative   bool              // import reports into DefectDojo in-process instead of running the defectdojo container
+	dojo         *dojoRun          // DefectDojo client and engagement for --dojo
+	params       paramSet          // parameters from every source, before they're matched up with tools
+	overrides    []string          // what per-app files, --app-profile and --tool-profile changed for this run
+	masker       *strings.Replacer // masks the secrets sent for this run
 	dryRun       bool
+	results      []*stepResult     // status of every step run or skipped, in order
+	reports      map[string]string // resolved reportname for each tool in this run
+	gateMax      map[string]int    // max-critical, max-high and max-medium from master.yaml, keyed by severity
+	gateWarn     bool              // gate-unread: warn in master.yaml, findings that couldn't be read don't fail the gates
+	failed       bool              // set when a step's failure fails the run
+	cancelled    bool              // set when the run's context was done before it completed
+	removed      []string          // containers and volumes removed by the cleanup stage
+	mu           sync.Mutex        // guards runContainer, runVolume, results and failed when tools run concurrently
+}
+
+// RunOpts holds the options for a run which aren't part of gasp's EventArgs
+type RunOpts struct {
+	Runtime      string // container runtime to use - docker, docker-api or podman
+	KeepVolume   bool   // keep the ephemeral data volume after the run
+	ExportVolume string // path of a tarball to export the ephemeral data volume to before it's removed
+	ParamsFile   string // YAML, JSON or .env file of tool parameters, see --params-file
+	AppConfDir   string // directory with [app-name]-pipeline.yaml and [app-name]-tool.yaml, defaults to the config directory
+	SecretsFile  string // NAME=value file of secrets e.g. DOJO_API_KEY, see --secrets-file
+	SARIF        string // file to write the run's findings to as a SARIF 2.1.0 log, see --sarif
+	JUnit        string // file to write the run's results to as JUnit XML, see --junit
+	JUnitAll     bool   // add a testcase to the JUnit XML for each finding of every tool, see --junit-findings
+	Dojo         bool   // import each tool's report into DefectDojo with its API, see --dojo
 }
 
-func listImages(ldock *LocalDockers) []Image {
-	infoLog.Println("Getting list of Docker images available in repo")
+func listImages(ldock *LocalDockers) ([]Image, error) {
+	infoLog.Printf("Getting list of images available in repo from %s", ldock.rt.Name())
 
-	cmd := exec.Command("docker", "images")
-	var sOut, sErr bytes.Buffer
-	cmd.Stdout = &sOut
-	cmd.Stderr = &sErr
-	err := cmd.Run()
+	images, err := ldock.rt.ListImages()
 	if err != nil {
-		errorLog.Printf("Error getting image list, errror was: %s", sOut.String())
-		errorLog.Println("Unable to get a list of available Docker images, quitting")
-		os.Exit(1)
-	}
-	//io.Copy(ldock.detailed, bytes.NewReader(sOut.Bytes()))
-	// TODO: update all exec.Command calls to output stdout and stderr on errors
-	//       also consider more info logs if needed after ^
-
-	scanner := bufio.NewScanner(bytes.NewReader(sOut.Bytes()))
-	images := make([]Image, 25)
-	for scanner.Scan() {
-		line := scanner.Text()
-		// Strip off header line, process the rest
-		if !strings.Contains(line, "REPOSITORY") {
-			bits := strings.Fields(line)
-			fn := bits[0] + ":" + bits[1]
-			im := Image{
-				fullName: fn,
-				name:     bits[0],
-				tag:      bits[1],
-				id:       bits[2],
-				created:  bits[3],
-				size:     bits[4],
-			}
-			images = append(images, im)
-		}
+		errorLog.Printf("Error getting image list, errror was: %s", err)
+		return nil, fmt.Errorf("unable to get a list of available images from %s: %v", ldock.rt.Name(), err)
 	}
-	return images
+	ldock.images = images
+
+	return images, nil
 }
 
 func diffImages(r []Image, s *g.S) map[string]bool {
@@ -226,19 +299,22 @@ func diffImages(r []Image, s *g.S) map[string]bool {
 	for _, val := range s.T {
 		inRepo[val.Docker] = false
 	}
+	// The helper image isn't a tool but runs with a data volume need it
+	// e.g. to set the volume's permissions and read reports from it
+	inRepo[helperImage] = false
 
 	// Compare needed against available in the repo
 	for _, img := range r {
 		// If the map has a key that's the name of an existing image, set to true aka available
-		if _, ok := inRepo[img.fullName]; ok {
-			inRepo[img.fullName] = true
-			infoLog.Printf("Image %s is available on this system, no need to pull", img.fullName)
+		if _, ok := inRepo[img.FullName]; ok {
+			inRepo[img.FullName] = true
+			infoLog.Printf("Image %s is available on this system, no need to pull", img.FullName)
 		}
 	}
 	return inRepo
 }
 
-func pullImages(i map[string]bool) {
+func pullImages(rt Runtime, i map[string]bool) error {
 	infoLog.Println("Pulling any needed images")
 
 	// Run through missing list and pull images as needed
@@ -250,15 +326,10 @@ func pullImages(i map[string]bool) {
 			fmt.Printf("Image needed, pulling image %s, this may take a bit.\n", k)
 			infoLog.Printf("Image needed, pulling image %s\n", k)
 			infoLog.Println("This will take a bit depending on network speeds")
-			cmd := exec.Command("docker", "pull", k)
-			var sOut, sErr bytes.Buffer
-			cmd.Stdout = &sOut
-			cmd.Stderr = &sErr
-			err := cmd.Run()
+			err := rt.PullImage(k)
 			if err != nil {
-				errorLog.Printf("Error pulling image %s, errror was: %s", k, sOut.String())
-				errorLog.Println("Unable to pull required Docker image, quitting")
-				os.Exit(1)
+				errorLog.Printf("Error pulling image %s, errror was: %s", k, err)
+				return fmt.Errorf("unable to pull required image %s: %v", k, err)
 			}
 			infoLog.Printf("Success pulling image %s\n", k)
 			c += 1
@@ -269,9 +340,11 @@ func pullImages(i map[string]bool) {
 		infoLog.Println("No images to pull")
 	}
 	infoLog.Println("Completed pulling needed images")
+
+	return nil
 }
 
-func dataVolume(run *runInfo) {
+func dataVolume(ctx context.Context, run *runInfo) error {
 	fmt.Println("In dataVolume")
 
 	// Create a data volume which will hold source and results for this run
@@ -280,8 +353,7 @@ func dataVolume(run *runInfo) {
 	if err != nil {
 		warnLog.Printf("Error creating data volme during startup stage of run %s", run.name)
 		errorLog.Printf("Error creating data volme was: %s", err)
-		errorLog.Println("Unable to data volume for this run, quitting")
-		os.Exit(1)
+		return err
 	}
 	fmt.Println("Created volume named ", vol)
 
@@ -289,13 +361,14 @@ func dataVolume(run *runInfo) {
 	run.dataVol = vol
 
 	// Adjust the file permission of the volume to use the appsecpipeline user
-	err = volumePerms(vol, run)
+	err = volumePerms(ctx, vol, run)
 	if err != nil {
 		warnLog.Printf("Error setting permissions on the data volme during startup stage of run %s", run.name)
 		errorLog.Printf("Error setting volme permissions was: %s", err)
-		errorLog.Println("Unable to set permissions on data volume for this run, quitting")
-		os.Exit(1)
+		return err
 	}
+
+	return nil
 }
 
 func launchVolume(run *runInfo) (string, error) {
@@ -314,26 +387,20 @@ func launchVolume(run *runInfo) (string, error) {
 
 	if !run.dryRun {
 		fmt.Println("NO DRY RUN - CREATING VOLUME")
-		cmd := exec.Command("docker", "volume", "create", vname)
-		var sOut, sErr bytes.Buffer
-		cmd.Stdout = &sOut
-		cmd.Stderr = &sErr
-		err := cmd.Run()
+		err := run.rt.CreateVolume(vname)
 		if err != nil {
-			errorLog.Printf("Error creating data volume %s, errror was: %s", vname, sOut.String())
-			errorLog.Println("Unable to create data volume, quitting")
-			os.Exit(1)
+			errorLog.Printf("Error creating data volume %s, errror was: %s", vname, err)
+			return "", fmt.Errorf("unable to create data volume %s: %v", vname, err)
 		}
-		io.Copy(run.detailed, bytes.NewReader(sOut.Bytes()))
+		run.runVolume = append(run.runVolume, vname)
 	}
 	infoLog.Printf("Success creating data volume %s\n", vname)
 
 	return vname, nil
 }
 
-func volumePerms(vol string, run *runInfo) error {
+func volumePerms(ctx context.Context, vol string, run *runInfo) error {
 	// Ajust the file permissions of the new volume so they are owned by the appsecpipeline user
-	volMount := vol + ":/opt/appsecpipeline/"
 	dName := "set-perms_" + run.runId
 
 	// TODO: Add this as a tool + pipeline step
@@ -342,21 +409,37 @@ func volumePerms(vol string, run *runInfo) error {
 	// Container can be any AppSec Pipeline image, since minimum named pipeline must have at least 1 tool for
 	// the pipeline stage, we can safely set the container name to the first pipeline tool's container image
 	//container := run.toolProfiles[(run.pipeline[0].Tool)].Docker
-	container := "mtesauro/gasp-base:1.0.0" // TODO: Revert this
+	container := helperImage // TODO: Revert this
+
+	// The reports directory is always created as tools write their reports
+	// to it.  Some runtimes (e.g. rootless podman) handle volume ownership
+	// themselves so they just get the mkdir, run as the image's user.
+	cmd := "mkdir -p " + reportDir + " && chown -R appsecpipeline:appsecpipeline /opt/appsecpipeline"
+	user := "root"
+	if vo, ok := run.rt.(VolumeOwner); ok && vo.OwnsVolumes() {
+		infoLog.Printf("The %s runtime sets ownership of data volume %s, skipping chown\n", run.rt.Name(), vol)
+		cmd, user = "mkdir -p "+reportDir, ""
+	}
+
 	if !run.dryRun {
-		cmd := exec.Command("docker", "run", "-v", volMount, "--name", dName,
-			"--user=root", "--rm", "--entrypoint", "chown", container,
-			"-R", "appsecpipeline:appsecpipeline", "/opt/appsecpipeline")
-		var sOut, sErr bytes.Buffer
-		cmd.Stdout = &sOut
-		cmd.Stderr = &sErr
-		err := cmd.Run()
+		spec := ContainerSpec{
+			Name:       dName,
+			Image:      container,
+			Cmd:        []string{"-c", cmd},
+			Entrypoint: "sh",
+			User:       user,
+			Mounts:     []Mount{{Source: vol, Target: "/opt/appsecpipeline/"}},
+			Remove:     true,
+		}
+		res, err := run.rt.RunContainer(ctx, spec)
+		if err == nil && res.ExitCode != 0 {
+			err = fmt.Errorf("exit code %d\n%s", res.ExitCode, res.Stderr)
+		}
 		if err != nil {
-			errorLog.Printf("Error setting file permissions on data volume %s, errror was: %s\n%s", vol, sErr.String(), err)
-			errorLog.Println("Unable to create data volume with needed file permissions, quitting")
-			os.Exit(1)
+			errorLog.Printf("Error setting file permissions on data volume %s, errror was: %s", vol, err)
+			return fmt.Errorf("unable to set file permissions on data volume %s: %v", vol, err)
 		}
-		io.Copy(run.detailed, bytes.NewReader(sOut.Bytes()))
+		io.Copy(run.detailed, bytes.NewReader(res.Stdout))
 	}
 	infoLog.Printf("Successfully set file permissions on data volume %s\n", vol)
 
@@ -364,163 +447,282 @@ func volumePerms(vol string, run *runInfo) error {
 
 }
 
-func launchContainer(tool g.Tools, run *runInfo) error {
+// Track a container kept with --keep so the cleanup stage can remove it
+func (run *runInfo) keptContainer(dName string) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	// Retried steps reuse their container's name
+	for _, c := range run.runContainer {
+		if c == dName {
+			return
+		}
+	}
+	run.runContainer = append(run.runContainer, dName)
+}
+
+// Write the contents of a data volume to a tarball on the local filesystem
+func exportVolume(vol string, tarball string, run *runInfo) error {
+	infoLog.Printf("Exporting data volume %s to %s\n", vol, tarball)
+
+	// tar the volume to stdout from a throwaway container
+	spec := ContainerSpec{
+		Name:       "export_" + run.runId,
+		Image:      helperImage,
+		Cmd:        []string{"-C", "/opt/appsecpipeline", "-cf", "-", "."},
+		Entrypoint: "tar",
+		User:       "root",
+		Mounts:     []Mount{{Source: vol, Target: "/opt/appsecpipeline/"}},
+		Remove:     true,
+	}
+	res, err := run.rt.RunContainer(context.Background(), spec)
+	if err == nil && res.ExitCode != 0 {
+		err = fmt.Errorf("tar exited with %d\n%s", res.ExitCode, res.Stderr)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(tarball, res.Stdout, 0644); err != nil {
+		return err
+	}
+	infoLog.Printf("Exported data volume %s to %s\n", vol, tarball)
+
+	return nil
+}
+
+func launchContainer(ctx context.Context, tool step, run *runInfo, sl *stepLog) (int, error) {
 	// Run the provided tool from this portion of the named pipeline run
-	dName := tool.Tool + "_" + run.runId
+	dName := tool.containerName(run)
 
 	// Deterine mounting for data volume(s) - local filesystem or emphemeral data volume
-	volMount := ""
+	mounts := make([]Mount, 0)
 	if run.Vol == "none" {
 		// Use the ephemeral data volume
-		volMount = run.dataVol + ":/opt/appsecpipeline/"
+		mounts = append(mounts, Mount{Source: run.dataVol, Target: "/opt/appsecpipeline/"})
 	} else {
 		// Use the provided local filesytem path
-		volMount = run.Vol + ":/opt/appsecpipeline/"
+		mounts = append(mounts, Mount{Source: run.Vol, Target: "/opt/appsecpipeline/"})
 	}
 
-	// Build the command for this tool
-	args := []string{
-		"run",
-		"-v", volMount,
+	// If provided, mount the local filesystem path that has source code
+	if run.Src != "none" {
+		lv := Mount{Source: run.Src, Target: run.loc}
+		fmt.Fprintf(&sl.console, "Local volume is:\n  =>%s:%s<=\n", lv.Source, lv.Target)
+		mounts = append(mounts, lv)
 	}
 
-	// Keep or remove container based on -k/--keep flag
-	if !run.keep {
-		args = append(args, "--rm")
+	// Reports are copied out of the data volume to -r/--reports after the
+	// tool runs, see collectReport
+
+	//"--user", "root", Not needed with gasp dockers
+	//"--entrypoint", Not needed with gasp dockers
+
+	toolCmd, err := genToolCmd(tool, run)
+	if err != nil {
+		errorLog.Printf("Unable to build the command for %s, error was: %s", tool.Tool, err)
+		return -1, fmt.Errorf("container %s not launched: %v", dName, err)
+	}
+	fmt.Fprintf(&sl.console, "Tool Command is %+v\n", toolCmd)
+	if toolCmd.shell && !toolCmd.exec {
+		// No exec means the image's entrypoint is the tool so there's no command for sh -c
+		infoLog.Printf("%s has shell set but no exec command, running it with its image's entrypoint", tool.Tool)
+	}
+	if len(toolCmd.exposed) > 0 {
+		// These will show up in ps output and docker inspect on the host
+		warnLog.Printf("%s has secrets in its container's command-line: %s", tool.Tool, strings.Join(toolCmd.exposed, ", "))
+		fmt.Printf("WARNING: %s can't read %s from its environment so the value will be on its container's command-line, set shell: True or secrets: env for it in secpipeline-config.yaml if it can\n",
+			tool.Tool, strings.Join(toolCmd.exposed, ", "))
+	}
+
+	// Build the container launch(es) for this tool, keeping or removing
+	// the container based on -k/--keep flag
+	base := ContainerSpec{
+		Name:    dName,
+		Image:   run.toolProfiles[tool.Tool].Docker,
+		Env:     tool.env,
+		Secrets: run.secretEnv(tool),
+		Mounts:  mounts,
+		HostNet: true,
+		Remove:  !run.keep,
+	}
+	specs, err := toolCmd.specs(base)
+	if err != nil {
+		errorLog.Printf("Unable to parse the command for %s, error was: %s", tool.Tool, err)
+		return -1, fmt.Errorf("container %s not launched: %v", dName, err)
 	}
 
-	// If provided, mount the local filesystem path that has source code
-	if run.Src != "none" {
-		lv := run.Src + ":/opt/appsecpipeline/source"
-		fmt.Printf("Local volume is:\n  =>%s<=\n", lv)
-		args = append(args, "-v", lv)
+	// Limit how long the tool can run based on max-tool-run, which covers
+	// any pre and post containers too
+	tctx := ctx
+	limit := tool.timeout(run.global)
+	if limit > 0 && !run.dryRun {
+		var cancel context.CancelFunc
+		tctx, cancel = context.WithTimeout(ctx, limit)
+		defer cancel()
+		infoLog.Printf("Container %s has a max-tool-run of %v\n", dName, limit)
 	}
 
-	//TODO: Do like the above for -r (reporting)
-	if run.Rpt != "none" {
-		rv := run.Rpt + ":/opt/appsecpipeline/source"
-		fmt.Printf("Local volume is:\n  =>%s<=\n", rv)
-		args = append(args, "-v", rv)
+	// Run the pre, main and post containers in turn, stopping at the first failure
+	for _, spec := range specs {
+		code, err := runSpec(ctx, tctx, spec, tool, limit, run, sl)
+		if err != nil {
+			return code, err
+		}
 	}
+	infoLog.Printf("Successfully launched container %s\n", dName)
 
-	// Add more default args
-	args = append(args, "--net=host", "--name", dName)
+	return 0, nil
+}
 
-	//"--user", "root", Not needed with gasp dockers
-	//"--entrypoint", Not needed with gasp dockers
+// Run a single container for a tool - ctx is the run's context and tctx
+// its max-tool-run limited child
+func runSpec(ctx context.Context, tctx context.Context, spec ContainerSpec, tool step, limit time.Duration, run *runInfo, sl *stepLog) (int, error) {
+	dName := spec.Name
 
-	// Add the container for the current tool
-	args = append(args, run.toolProfiles[tool.Tool].Docker)
+	// Log what was sent to the runtime for this run
+	infoLog.Print(run.mask(fmt.Sprintf("Container spec sent to %s was %+v\n", run.rt.Name(), spec)))
+	fmt.Fprintf(&sl.console, "Container spec sent to %s was %+v\n", run.rt.Name(), spec)
 
-	toolCmd := genToolCmd(tool.Tool, tool.ToolProfile, run)
-	fmt.Printf("Tool Command is %+v\n", toolCmd)
+	if run.dryRun {
+		return 0, nil
+	}
 
-	// Append the rest of the command args
-	args = append(args, strings.Split(toolCmd, " ")...)
+	// Run the container
+	res, err := run.rt.RunContainer(tctx, spec)
+	if err != nil && ctx.Err() != nil {
+		// The run was cancelled e.g. Ctrl-C so stop the tool where it is
+		return -1, cancelContainer(dName, tool, run, sl)
+	}
+	if err != nil && tctx.Err() == context.DeadlineExceeded {
+		return -1, stopContainer(dName, tool, limit, run, sl)
+	}
+	// A kept container that exited, even unsuccessfully, is left for the cleanup stage
+	if run.keep && (err == nil || res.ExitCode != 0) {
+		run.keptContainer(dName)
+	}
+	if err == nil && res.ExitCode != 0 {
+		err = fmt.Errorf("exit code %d", res.ExitCode)
+	}
+	if err != nil {
+		errorLog.Print(run.mask(fmt.Sprintf("Error launching container %s, errror was: %s\n%s\n%s", dName, res.Stderr, err, res.Stdout)))
+		sl.detailed.Write(res.Stdout)
+		if res.ExitCode != 0 {
+			return res.ExitCode, fmt.Errorf("container %s failed: %v", dName, err)
+		}
+		return -1, fmt.Errorf("container %s failed: %v", dName, err)
+	}
+	sl.detailed.Write(res.Stdout)
+	// TODO: Write these to gasp-log
+	fmt.Fprintf(&sl.console, "StdOut is %v\n", string(res.Stdout))
+	fmt.Fprintf(&sl.console, "StdErr is %v\n", string(res.Stderr))
 
-	// Log what was sent to docker for this run
-	infoLog.Printf("ARGS sent to docker were %+v\n", args)
-	fmt.Printf("ARGS sent to docker were %+v\n", args)
+	return 0, nil
+}
 
-	if !run.dryRun {
-		// Run the container
-		cmd := exec.Command("docker", args...)
-		var sOut, sErr bytes.Buffer
-		cmd.Stdout = &sOut
-		cmd.Stderr = &sErr
-		err := cmd.Run()
-		if err != nil {
-			errorLog.Printf("Error launching container %s, errror was: %s\n%s\n%s", dName, sErr.String(), err, sOut.String())
-			errorLog.Println("Unable to launch container for pipeline stage, quitting")
-			os.Exit(1)
-		}
-		io.Copy(run.detailed, bytes.NewReader(sOut.Bytes()))
-		// TODO: Write these to gasp-log
-		fmt.Printf("StdOut is %v\n", sOut.String())
-		fmt.Printf("StdErr is %v\n", sErr.String())
+// Stop and remove a container which ran past its max-tool-run
+func stopContainer(dName string, tool step, limit time.Duration, run *runInfo, sl *stepLog) error {
+	terr := &timeoutError{tool: tool.Tool, limit: limit}
+	warnLog.Printf("Container %s timed out: %s\n", dName, terr)
+	fmt.Fprintf(&sl.console, "TIMEOUT: %s\n", terr)
+	fmt.Fprintf(&sl.detailed, "TIMEOUT: container %s - %s\n", dName, terr)
+
+	if err := run.rt.Kill(dName); err != nil {
+		warnLog.Printf("Unable to kill timed out container %s, error was: %s\n", dName, err)
 	}
-	infoLog.Printf("Successfully launched container %s\n", dName)
+	if err := run.rt.RemoveContainer(dName); err != nil {
+		warnLog.Printf("Unable to remove timed out container %s, error was: %s\n", dName, err)
+	}
+	infoLog.Printf("Stopped and removed timed out container %s\n", dName)
 
-	return nil
+	return terr
+}
+
+// Stop a container which was still running when the run was cancelled,
+// removing it unless --keep was used
+func cancelContainer(dName string, tool step, run *runInfo, sl *stepLog) error {
+	warnLog.Printf("Run cancelled, stopping container %s\n", dName)
+	fmt.Fprintf(&sl.console, "CANCELLED: stopping container %s\n", dName)
+	fmt.Fprintf(&sl.detailed, "CANCELLED: container %s was stopped as the run was cancelled\n", dName)
+
+	if err := run.rt.Kill(dName); err != nil {
+		warnLog.Printf("Unable to kill container %s, error was: %s\n", dName, err)
+	}
+	if run.keep {
+		run.keptContainer(dName)
+	} else if err := run.rt.RemoveContainer(dName); err != nil {
+		warnLog.Printf("Unable to remove container %s, error was: %s\n", dName, err)
+	}
+
+	return fmt.Errorf("%s was stopped as the run was cancelled", tool.Tool)
 }
 
-func genToolCmd(tool string, toolProf string, run *runInfo) string {
-	cmd := ""
+func genToolCmd(s step, run *runInfo) (toolCommand, error) {
+	tool := s.Tool
+	tc := toolCommand{shell: toolShell(run.toolProfiles[tool])}
+	tc.exec = len(run.toolProfiles[tool].Cmds["exec"]) > 0
+	main := ""
+	missing := make(map[string]bool)
+
+	// Secrets are in the container's environment so a shell command can
+	// reference them instead of having them in its arguments.  Without a
+	// shell, only the main command can and only if the image's entrypoint
+	// expands them (secrets: env) - pre and post replace the entrypoint
+	secrets := run.secretEnv(s)
+	refs := make(map[string]bool)
+	mainRefs := make(map[string]bool)
+	for _, e := range secrets {
+		if tc.shell && tc.exec {
+			refs[envName(e)] = true
+		}
+		if (tc.shell && tc.exec) || toolSecretsEnv(run.toolProfiles[tool]) {
+			mainRefs[envName(e)] = true
+		}
+	}
 
 	// Pull out the commands for this tool - starting with the pre-command
 	if pre, _ := run.toolProfiles[tool].Cmds["pre"]; len(pre) > 0 {
 		// Add pre command since it exists
-		cmd += cmdSub(pre, tool, run) + " "
+		tc.pre = cmdSub(pre, s, run, missing, refs)
 	}
 	// The exec or main command
 	if exec, _ := run.toolProfiles[tool].Cmds["exec"]; len(exec) > 0 {
-		cmd += cmdSub(exec, tool, run) + " "
+		main += cmdSub(exec, s, run, missing, mainRefs) + " "
 	}
 	// Check if report option is provided
 	if rep, _ := run.toolProfiles[tool].Cmds["report"]; len(rep) > 0 {
 		// Add report option
-		cmd += cmdSub(rep, tool, run) + " "
+		main += cmdSub(rep, s, run, missing, mainRefs) + " "
 	}
 	// Substitue out any passed in values e.g. LOC=/opt/appsecpipeline/source
-	prf := run.toolProfiles[tool].Pfls[toolProf]
-	cmd += cmdSub(prf, tool, run) + " "
+	prf := run.toolProfiles[tool].Pfls[s.ToolProfile]
+	main += cmdSub(prf, s, run, missing, mainRefs)
+	tc.main = strings.TrimSpace(main)
 	// Check if a post is provided
 	if post, _ := run.toolProfiles[tool].Cmds["post"]; len(post) > 0 {
 		// Add post command
-		cmd += " && " + cmdSub(post, tool, run) + " "
+		tc.post = cmdSub(post, s, run, missing, refs)
 	}
-
-	return cmd
-}
-
-func cmdSub(c string, tool string, run *runInfo) string {
-	// Do substitutions to build a a new command
-	newCmd := c
-
-	// Check for { in the string sent for substitutions from secpipeline-config.yaml
-	if strings.Contains(c, "{") {
-		// Pull out the string inside of { }
-		v := c[(strings.Index(c, "{") + 1):strings.Index(c, "}")]
-		// Currently, only reportname and timestamp are supported in the yaml
-		switch v {
-		case "reportname":
-			newCmd = strings.Replace(newCmd, "{reportname}", cmdSub(run.toolProfiles[tool].Cmds[v], tool, run), -1)
-		case "timestamp":
-			stamp := strconv.Itoa(int(time.Now().UnixNano()))
-			// TODO: Check if Unix nanoseconds is right timestamp to use
-			newCmd = strings.Replace(newCmd, "{timestamp}", stamp, -1)
-		default:
-			warnLog.Printf("Unknown {} substitution - %v", v)
-			errorLog.Printf("No substituion made for %v", v)
+	// Anything that couldn't be referenced ends up with the secret's value
+	for _, e := range secrets {
+		kv := strings.SplitN(e, "=", 2)
+		if kv[1] != "" && strings.Contains(tc.String(), kv[1]) {
+			tc.exposed = append(tc.exposed, kv[0])
 		}
 	}
-
-	// Run through the command to send to the container and make any needed substitutions
-	for _, word := range strings.Split(newCmd, " ") {
-		if strings.Contains(word, "$") {
-			// Subsitute out any variables
-			var to []string
-			cleanedParams := strings.Trim(run.sentParams[tool], " ")
-			cleanedParams = strings.Replace(cleanedParams, "  ", " ", -1)
-			for _, v := range strings.Split(cleanedParams, " ") {
-				// Avoid any empty strings
-				if len(v) > 0 {
-					// Vars to swap out start with $
-					cmdVar := word[strings.Index(word, "$")+1:]
-					if strings.Contains(v, cmdVar) {
-						// Match found, do the replacement
-						to = strings.Split(string(v), "=")
-						newCmd = strings.Replace(newCmd, word[strings.Index(word, "$"):], to[1], 1)
-					}
-				}
-			}
+	// The reportname can also have placeholders
+	if _, un := run.expandReportName(tool); len(un) > 0 {
+		for _, u := range un {
+			missing[u] = true
 		}
 	}
 
-	return newCmd
+	if len(missing) > 0 {
+		return tc, unresolvedError(s, missing)
+	}
+	return tc, nil
 }
 
-func verifyRun(ev *g.EventArgs, mstr *g.M, sec *g.S, run *runInfo) {
+func verifyRun(ev *g.EventArgs, mstr *g.M, mc *masterConf, sec *g.S, run *runInfo) error {
 	// Sanity check the provided arguments vs the config files for any issues before starting the run
 	fmt.Println("In verifyRun")
 
@@ -531,86 +733,84 @@ func verifyRun(ev *g.EventArgs, mstr *g.M, sec *g.S, run *runInfo) {
 	run.Src = ev.Src
 	run.Rpt = ev.Rpt
 
+	// Where the source code is inside the containers, from --location
+	run.loc = ev.Loc
+	if run.loc == "" {
+		run.loc = defaultLoc
+	}
+	if !path.IsAbs(run.loc) {
+		return fmt.Errorf("--location must be an absolute path inside the containers, not '%s'", ev.Loc)
+	}
+	run.loc = path.Clean(run.loc)
+
+	// --app-profile runs a named pipeline from [app-name]-pipeline.yaml
+	if ev.AppProfile != "" && ev.AppProfile != "none" {
+		if _, ok := mc.profFrom[ev.AppProfile]; !ok {
+			return fmt.Errorf("no named pipeline '%s' is defined in %s-pipeline.yaml for --app-profile", ev.AppProfile, ev.AppName)
+		}
+		if ev.Profile != ev.AppProfile {
+			warnLog.Printf("Running app named pipeline '%s' instead of '%s'", ev.AppProfile, ev.Profile)
+			run.overrides = append(run.overrides, fmt.Sprintf("--app-profile '%s' is run instead of --profile '%s'", ev.AppProfile, ev.Profile))
+		}
+		ev.Profile = ev.AppProfile
+	}
+
 	// Set the named pipeline for this run
 	run.name = ev.Profile
+	run.appName = ev.AppName
+	if _, ok := mstr.Prof[ev.Profile]; !ok {
+		warnLog.Printf("Named pipeline '%s' was not found in master.yaml", ev.Profile)
+		return fmt.Errorf("no named pipeline '%s' is defined in master.yaml", ev.Profile)
+	}
 
-	// Look for [app-name]-pipeline.yaml
-	// TODO: Add a check for this file in the config directory in verifyRun() [app-name]-pipeline.yaml
+	// Global settings e.g. max-parallel and max-dynamic
+	run.global = mstr.Global
 
-	// Look for [app-name]-tool.yaml
-	// TODO: Add a check for this file in the config directory in verifyRun() [app-name]-tool.yaml
+	// Any [app-name]-pipeline.yaml and [app-name]-tool.yaml were merged
+	// into mstr, mc and sec when the configs were read
 
-	// Get a list of all tools used in this run and the parameters sent in the command-line
+	// Get a list of all tools used in this run
 	tools := make([]string, 0)
-	conf := make(map[string]string)
 	tc := 0
-	ts := make(map[int]g.Tools)
+	ts := make(map[int]step)
 	// Collect startup tools and assign their options for this run
 	for _, s := range mstr.Prof[ev.Profile].Startup {
 		tools = append(tools, s.Tool)
 		// Pull out the tool and options set in the startup profile for this run
-		ts[tc] = s
-		found := ""
-		for sp, _ := range sec.T[s.Tool].Parameters {
-			// For each parameter for this tool, add any that were provided in the command-line
-			found += setOption(sp, ev.ParamsRaw)
-			found += " "
-		}
-		conf[s.Tool] = (strings.TrimRight(found, " "))
+		ts[tc] = newStep(s, tc, mc.Prof[ev.Profile].Startup)
 		tc++
 	}
 	run.startup = ts
 
 	// Collect pipeline tools and assign their options for this run
 	tc = 0
-	tp := make(map[int]g.Tools)
+	tp := make(map[int]step)
 	for _, p := range mstr.Prof[ev.Profile].Pipeline {
 		tools = append(tools, p.Tool)
 		// Pull out the tool and options set in the pipeline profile for this run
-		tp[tc] = p
-		found := ""
-		for pp, _ := range sec.T[p.Tool].Parameters {
-			// For each parameter for this tool, add any that were provided in the command-line
-			found += setOption(pp, ev.ParamsRaw)
-			found += " "
-		}
-		conf[p.Tool] = (strings.TrimRight(found, " "))
+		tp[tc] = newStep(p, tc, mc.Prof[ev.Profile].Pipeline)
 		tc++
 	}
 	run.pipeline = tp
 
 	// Collect final tools and assign their options for this run
 	tc = 0
-	tf := make(map[int]g.Tools)
+	tf := make(map[int]step)
 	for _, f := range mstr.Prof[ev.Profile].Final {
 		tools = append(tools, f.Tool)
 		// Pull out the tool and options set in the pipeline profile for this run
-		tf[tc] = f
-		found := ""
-		for fp, _ := range sec.T[f.Tool].Parameters {
-			// For each parameter for this tool, add any that were provided in the command-line
-			found += setOption(fp, ev.ParamsRaw)
-			found += " "
-		}
-		conf[f.Tool] = (strings.TrimRight(found, " "))
+		tf[tc] = newStep(f, tc, mc.Prof[ev.Profile].Final)
 		tc++
 	}
 	run.final = tf
 
 	// Collect runevery tools and assign their options for this run
 	tc = 0
-	tr := make(map[int]g.Tools)
+	tr := make(map[int]step)
 	for _, r := range mstr.Prof[ev.Profile].RunEvery {
 		tools = append(tools, r.Tool)
 		// Pull out the tool and options set in the pipeline profile for this run
-		tr[tc] = r
-		found := ""
-		for rp, _ := range sec.T[r.Tool].Parameters {
-			// For each parameter for this tool, add any that were provided in the command-line
-			found += setOption(rp, ev.ParamsRaw)
-			found += " "
-		}
-		conf[r.Tool] = (strings.TrimRight(found, " "))
+		tr[tc] = newStep(r, tc, mc.Prof[ev.Profile].RunEvery)
 		tc++
 	}
 	run.runevery = tr
@@ -618,208 +818,140 @@ func verifyRun(ev *g.EventArgs, mstr *g.M, sec *g.S, run *runInfo) {
 	// Cycle through tools used in this run and pull their profiles from sec - the datastructure for secpipeline-config.yaml
 	run.toolProfiles = make(map[string]g.SecTool)
 	for _, tool := range tools {
-		pullToolProfile(tool, run, sec)
+		if err := pullToolProfile(tool, run, sec); err != nil {
+			return err
+		}
+	}
+
+	// --dojo takes its settings from the defectdojo tool's parameters even if
+	// it isn't in the named pipeline
+	if run.dojoNative {
+		if _, ok := run.toolProfiles[dojoTool]; !ok {
+			if err := pullToolProfile(dojoTool, run, sec); err != nil {
+				return fmt.Errorf("--dojo needs the %s tool in secpipeline-config.yaml for its parameters", dojoTool)
+			}
+		}
+	}
+
+	// --tool-profile swaps in a tool-profile for every tool which has it
+	if ev.AppToolProf != "" && ev.AppToolProf != "none" {
+		changed, err := useToolProfile(run, ev.AppToolProf)
+		if err != nil {
+			return err
+		}
+		run.overrides = append(run.overrides, changed...)
 	}
 
 	// Verify that the option in the profile exists for the tool
-	verifyOptions(run)
+	if err := verifyOptions(run); err != nil {
+		return err
+	}
 
-	// TODO: Verify that there's at least 1 tool defined in the pipeline stage
-	//       - that's the smallest possible named pipeline
+	// Verify each step's on-failure policy
+	if err := verifyPolicies(run); err != nil {
+		return err
+	}
 
-	// Set run's sentParams with the tool parameters provided by the command-line
-	run.sentParams = conf
+	// Verify the min-severity and max findings for the quality gates
+	if err := verifySeverities(run); err != nil {
+		return err
+	}
+	gateMax, err := gateLimits(mc.Global)
+	if err != nil {
+		return err
+	}
+	run.gateMax = gateMax
+	if run.gateWarn, err = gateWarnUnread(mc.Global); err != nil {
+		return err
+	}
 
-	//fmt.Printf("defectdojo's args are: %+v\n", run.sentParams["defectdojo"])
-}
+	// Verify that there's at least 1 tool defined in the pipeline stage
+	// - that's the smallest possible named pipeline
+	if len(run.pipeline) < 1 {
+		return fmt.Errorf("the '%s' named pipeline has no tools in its pipeline stage", run.name)
+	}
 
-// If the needed parameter was sent in the command-line (arg) then
-// return the matching NAME=value string, else return an empty string
-func setOption(param string, arg string) string {
+	// Set run's sentParams with the tool parameters provided by the command-line
+	// on top of any defaults, params or secrets file and environment variables
+	params, err := parseParams(ev.ParamsRaw)
+	if err != nil {
+		return err
+	}
+	run.params, err = resolveParams(run, mc, params)
+	if err != nil {
+		return err
+	}
+	run.sentParams = run.params.forTools(run.toolProfiles)
+	run.setSecrets()
 
-	// Split up the name=value pairs that were provided on the command-line
-	vars := strings.Split(arg, " ")
+	// Verify the parameters sent match their data_type and none are missing
+	if err := verifyParams(run); err != nil {
+		return err
+	}
 
-	for _, v := range vars {
-		// Try to match the command-line arg to a parameter needed by the current tool
-		if strings.Contains(v, param) {
-			// Tool parameter was sent as a commmand-line argument so return it
-			return v
+	// Set up the DefectDojo client for --dojo, nothing is sent until the run starts
+	if run.dojoNative {
+		if run.dojo, err = newDojoRun(run); err != nil {
+			return err
 		}
 	}
 
-	// Tool parameter wasn't sent as a command-line arguement to return empty string
-	return ""
+	//fmt.Printf("defectdojo's args are: %+v\n", run.sentParams["defectdojo"])
+	return nil
 }
 
 // Take a tool name, get that profile from sec (secpipeline-config.yaml) and
 // add it to current run struct (runInfo)
-func pullToolProfile(tool string, run *runInfo, sec *g.S) {
+func pullToolProfile(tool string, run *runInfo, sec *g.S) error {
 
 	// Check the map for a key that's the current tool, set OK to true if it exists
 	_, ok := sec.T[tool]
 	if !ok {
-		// Tool was in profile that doesn't have configuration, this is a fatal error
+		// Tool was in profile that doesn't have configuration, the run can't go on
 		warnLog.Printf("Tool '%s' was in the current profile but is not defined in secpipeline-config.yaml", tool)
-		errorLog.Println("FATAL: Unable to find a tool profile for the tool requested in this pipeline run, quitting")
-		os.Exit(1)
+		return fmt.Errorf("tool '%s' is in the '%s' named pipeline but is not defined in secpipeline-config.yaml", tool, run.name)
 	}
 	// Since the tool exists in sec (secpipeline-config.yaml), add its config to the current run
 	run.toolProfiles[tool] = sec.T[tool]
+
+	return nil
 }
 
 // Verify that the option in the profile exists for the tool
 // So if the profile has the 'git' tool and uses the option 'merge',
 // secpipeline-config.yaml will be checked to ensure that option exists.  If not,
-// it will fail fatally and log an error message.
-func verifyOptions(run *runInfo) {
+// an error is returned naming the stage, tool and option.
+func verifyOptions(run *runInfo) error {
 	infoLog.Println("In verifyOptions")
 
-	// Check startup's tool profiles
-	for _, s := range run.startup {
-		infoLog.Printf("The '%v' named pipeline's startup stage included the '%v' tool with an option of '%v'\n", run.name, s.Tool, s.ToolProfile)
-		infoLog.Printf("The option for '%v' in the master profile is %v\n", s.Tool, run.toolProfiles[s.Tool].Pfls[s.ToolProfile])
-		_, ok := run.toolProfiles[s.Tool].Pfls[s.ToolProfile]
-		if !ok {
-			// Tool profile sent doesn't exist for tool
-			warnLog.Printf("The '%v' option for '%v' was not found", s.ToolProfile, s.Tool)
-			errorLog.Printf("FATAL: No option of '%v' is defined for '%v' in secpipeline-config.yaml, quitting", s.ToolProfile, s.Tool)
-			os.Exit(1)
-		}
-	}
-
-	// Check pipeline's tool profiles
-	for _, p := range run.pipeline {
-		infoLog.Printf("The '%v' named pipeline's pipeline stage included the '%v' tool with an option of '%v'\n", run.name, p.Tool, p.ToolProfile)
-		infoLog.Printf("The option for '%v' in the master profile is %v\n", p.Tool, run.toolProfiles[p.Tool].Pfls[p.ToolProfile])
-		_, ok := run.toolProfiles[p.Tool].Pfls[p.ToolProfile]
-		if !ok {
-			// Tool profile sent doesn't exist for tool
-			warnLog.Printf("The '%v' option for '%v' was not found", p.ToolProfile, p.Tool)
-			errorLog.Printf("FATAL: No option of '%v' is defined for '%v' in secpipeline-config.yaml, quitting", p.ToolProfile, p.Tool)
-			os.Exit(1)
-		}
-	}
-
-	// Check final's tool profiles
-	for _, f := range run.final {
-		infoLog.Printf("The '%v' named pipeline's final stage included the '%v' tool with an option of '%v'\n", run.name, f.Tool, f.ToolProfile)
-		infoLog.Printf("The option for '%v' in the master profile is %v\n", f.Tool, run.toolProfiles[f.Tool].Pfls[f.ToolProfile])
-		_, ok := run.toolProfiles[f.Tool].Pfls[f.ToolProfile]
-		if !ok {
-			// Tool profile sent doesn't exist for tool
-			warnLog.Printf("The '%v' option for '%v' was not found", f.ToolProfile, f.Tool)
-			errorLog.Printf("FATAL: No option of '%v' is defined for '%v' in secpipeline-config.yaml, quitting", f.ToolProfile, f.Tool)
-			os.Exit(1)
+	for _, st := range run.stages() {
+		for i := 0; i < len(st.steps); i++ {
+			s := st.steps[i]
+			infoLog.Printf("The '%v' named pipeline's %v stage included the '%v' tool with an option of '%v'\n", run.name, st.name, s.Tool, s.ToolProfile)
+			infoLog.Printf("The option for '%v' in the master profile is %v\n", s.Tool, run.toolProfiles[s.Tool].Pfls[s.ToolProfile])
+			_, ok := run.toolProfiles[s.Tool].Pfls[s.ToolProfile]
+			if !ok {
+				// Tool profile sent doesn't exist for tool
+				warnLog.Printf("The '%v' option for '%v' was not found", s.ToolProfile, s.Tool)
+				return fmt.Errorf("no option of '%v' is defined for '%v' (%v stage) in secpipeline-config.yaml", s.ToolProfile, s.Tool, st.name)
+			}
 		}
 	}
 
-	// Check runevery's tool profiles
-	for _, r := range run.runevery {
-		infoLog.Printf("The '%v' named pipeline's runevery stage included the '%v' tool with an option of '%v'\n", run.name, r.Tool, r.ToolProfile)
-		infoLog.Printf("The option for '%v' in the master profile is %v\n", r.Tool, run.toolProfiles[r.Tool].Pfls[r.ToolProfile])
-		_, ok := run.toolProfiles[r.Tool].Pfls[r.ToolProfile]
-		if !ok {
-			// Option sent doesn't exist for tool
-			warnLog.Printf("The '%v' option for '%v' was not found", r.ToolProfile, r.Tool)
-			errorLog.Printf("FATAL: No option of '%v' is defined for '%v' in secpipeline-config.yaml, quitting", r.ToolProfile, r.Tool)
-			os.Exit(1)
-		}
-	}
+	return nil
 }
 
-func LoadPipeline(args *g.EventArgs) {
-	// Start gasp-docker logging
-	l := g.SetupLogging("gasp-docker", logDir, true)
-	traceLog = l["trace"]
-	infoLog = l["info"]
-	warnLog = l["warn"]
-	errorLog = l["error"]
-	infoLog.Println("Logging setup for gasp-docker")
-
-	// Set the directory location of the config files (master.yaml & secpipeline-config.yaml)
-	subdir := "./spec"
-
-	// Check Dependencies
-	d := g.Deps{
-		[]string{"docker"},
-		[]string{"master.yaml", "secpipeline-config.yaml"},
-		subdir,
-		[]string{},
-	}
-	ld := g.LocalDeps{}
-	ld.VerifyPrereqs(d)
-	infoLog.Println("All dependencies needed for gasp-docker are available")
-
-	// Read the configs to set things up
-	lconf := g.LocalConfigs{"master.yaml", "secpipeline-config.yaml"}
-	mstr := g.M{}
-	lopts := g.ConfigOpts{"Local Files", subdir}
-	lconf.ReadMaster(&mstr, lopts)
-	sec := g.S{}
-	lconf.ReadSecPipe(&sec, lopts)
-
-	// Setup struct for tracking container images
-	ldock := LocalDockers{}
-
-	// Sync images so all needed tool images are in image repo
-	ldock.SyncImages(&sec)
-	//TODO: Look through yaml files to make sure they are consistent on image names & versions
-
-	// handleEvent
-	eArgs := g.EventArgs{}
-	le := LocalEvent{}
-	le.ReadArgs(args, &eArgs)
-
-	// Verify the event's data against what's needed for this run
-	// And set runInfo with this runs data if everything checks out
-	//singleRun := new(runInfo)
-	singleRun := runInfo{}
-	verifyRun(&eArgs, &mstr, &sec, &singleRun)
-
-	// Run the sent Named Profile
-	singleRun.runId = le.GetId()
-
-	// Initialize detailed logging
-	fullPath := path.Join(logDir, (singleRun.runId + "_detailed.log"))
-	dL, err := os.Create(fullPath)
-	if err != nil {
-		fmt.Printf("\nPlease create any directories needed to write logs to %v\n\n", logDir)
-		log.Fatalf("Failed to open log file %s.  Error was:\n  %+v\n", fullPath, err)
+// Check every step's on-failure policy is one gasp-docker knows how to handle
+func verifyPolicies(run *runInfo) error {
+	for _, st := range run.stages() {
+		for i := 0; i < len(st.steps); i++ {
+			s := st.steps[i]
+			if _, err := parsePolicy(s.OnFailure); err != nil {
+				warnLog.Printf("The on-failure for '%v' in the %v stage is invalid: %s", s.Tool, st.name, err)
+				return fmt.Errorf("invalid on-failure for %v in the %v stage of master.yaml: %v", s.Tool, st.name, err)
+			}
+		}
 	}
-	singleRun.detailed = dL
-	defer dL.Close()
-
-	//	fmt.Printf("The value of keep is %v\n", singleRun.keep)
-	//	fmt.Printf("The value of dryRun is %v\n", singleRun.dryRun)
-	//	fmt.Printf("The type of keep is %T\n", singleRun.keep)
-	//	fmt.Printf("The type of dryRun is %T\n", singleRun.dryRun)
-	//os.Exit(0)
-
-	// Run startup stage
-	le.Startup(&singleRun)
-
-	// Run pipeline stage
-	le.Pipeline(&singleRun)
-
-	// Run final stage
-	le.Final(&singleRun)
-
-	// Run cleanup stage - not needed for local dockers if the --rm options is used
-	//le.Pipeline(&singleRun)
-
-	// TODO: Add more meta to the detailed log - maybe push everything into the main log
-	// TODO: Set a version number for that command line option
-
-	// DEBUG INFO
-	fmt.Println("\nDEBUG INFO\n")
-	fmt.Println("Clean up dockers from ths run with:")
-	//fmt.Printf("docker rm set-perms_%s\n", singleRun.runId)
-	//fmt.Printf("docker rm git_%s\n", singleRun.runId)
-	//fmt.Printf("docker rm cloc_%s\n", singleRun.runId)
-	//fmt.Printf("docker rm bandit_%s\n", singleRun.runId)
-	//fmt.Printf("docker rm defectdojo_%s\n", singleRun.runId)
-	fmt.Printf("docker volume rm data_%s\n\n", singleRun.runId)
-	os.Exit(0)
 
+	return nil
 }
@@ -0,0 +1,215 @@
+// gdocker
+package gdocker
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/appsecpipeline/gasp-docker/findings"
+)
+
+// JUnit XML as read by Jenkins and GitLab
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Type    string `xml:"type,attr"`
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// Seconds as JUnit likes them
+func junitTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// Check if a step's findings get their own testcases - --junit-findings
+// or a junit command for the tool in secpipeline-config.yaml
+func (run *runInfo) junitFindings(r *stepResult) bool {
+	return run.junitAll || run.toolProfiles[r.tool.Tool].Cmds["junit"] != ""
+}
+
+// The testcase for a step, failed on a non-zero exit or timeout
+func (run *runInfo) junitStep(r *stepResult) junitCase {
+	tc := junitCase{
+		Name:      fmt.Sprintf("%s (%s)", r.tool.Tool, r.tool.ToolProfile),
+		ClassName: run.name + "." + r.stage,
+		Time:      junitTime(r.duration),
+	}
+
+	switch r.status {
+	case statusSkipped:
+		tc.Skipped = &junitSkipped{Message: "skipped due to an earlier failure"}
+		if run.cancelled {
+			tc.Skipped.Message = "skipped, the run was cancelled"
+		}
+	case statusCancelled:
+		tc.Skipped = &junitSkipped{Message: "cancelled"}
+	case statusFailed, statusTolerated:
+		f := &junitFailure{Type: "exit-code", Message: fmt.Sprintf("exited with %d", r.exitCode)}
+		if _, ok := r.err.(*timeoutError); ok {
+			f.Type, f.Message = "timeout", r.err.Error()
+		}
+		if r.status == statusTolerated {
+			f.Message += ", tolerated by on-failure " + r.tool.OnFailure
+		}
+		if r.err != nil {
+			f.Text = run.mask(r.err.Error())
+		}
+		tc.Failure = f
+	}
+	return tc
+}
+
+// A failed testcase for each of a step's findings at or above its
+// min-severity
+func (run *runInfo) junitFindingCases(r *stepResult) []junitCase {
+	cases := make([]junitCase, 0)
+	min := r.tool.minSeverity(run.global)
+	for _, f := range r.found {
+		if !findings.AtLeast(f.Severity, min) {
+			continue
+		}
+		name := f.Title
+		if f.RuleID != "" {
+			name = f.RuleID + ": " + name
+		}
+		loc := f.Location
+		if f.Line > 0 {
+			loc += ":" + strconv.Itoa(f.Line)
+		}
+		if loc != "" {
+			name += " at " + loc
+		}
+		cases = append(cases, junitCase{
+			Name:      fmt.Sprintf("[%s] %s", findings.Severity(f.Severity), name),
+			ClassName: run.name + "." + r.stage + "." + r.tool.Tool,
+			Time:      junitTime(0),
+			Failure:   &junitFailure{Type: "finding-" + findings.Severity(f.Severity), Message: f.Title, Text: run.mask(f.Evidence)},
+		})
+	}
+	return cases
+}
+
+// Build the JUnit XML for a run with a testsuite per stage
+func (run *runInfo) junit() junitSuites {
+	js := junitSuites{Name: "gasp-docker " + run.name, Suites: make([]junitSuite, 0)}
+	props := []junitProperty{
+		{Name: "gasp-run-id", Value: run.runId},
+		{Name: "app-name", Value: run.appName},
+		{Name: "profile", Value: run.name},
+	}
+
+	var total time.Duration
+	for _, st := range run.stages() {
+		suite := junitSuite{Name: st.name, Properties: props, Cases: make([]junitCase, 0)}
+		var d time.Duration
+		for _, r := range run.results {
+			if r.stage != st.name {
+				continue
+			}
+			d += r.duration
+			suite.Cases = append(suite.Cases, run.junitStep(r))
+			if run.junitFindings(r) {
+				suite.Cases = append(suite.Cases, run.junitFindingCases(r)...)
+			}
+		}
+		if len(suite.Cases) == 0 {
+			continue
+		}
+
+		for _, tc := range suite.Cases {
+			suite.Tests++
+			if tc.Failure != nil {
+				suite.Failures++
+			}
+			if tc.Skipped != nil {
+				suite.Skipped++
+			}
+		}
+		suite.Time = junitTime(d)
+		js.Tests += suite.Tests
+		js.Failures += suite.Failures
+		js.Skipped += suite.Skipped
+		total += d
+		js.Suites = append(js.Suites, suite)
+	}
+	js.Time = junitTime(total)
+
+	return js
+}
+
+// Write the run's results as JUnit XML
+func (run *runInfo) writeJUnit(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(run.junit()); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Write the JUnit XML to the file from --junit
+func (run *runInfo) writeJUnitFile() error {
+	if run.junitFile == "" || run.dryRun {
+		return nil
+	}
+
+	f, err := os.Create(run.junitFile)
+	if err != nil {
+		return err
+	}
+	if err := run.writeJUnit(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	infoLog.Printf("Wrote the run's results as JUnit XML to %s", run.junitFile)
+	fmt.Printf("JUnit XML written to %s\n", run.junitFile)
+
+	return nil
+}
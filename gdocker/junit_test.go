@@ -0,0 +1,103 @@
+// gdocker
+package gdocker
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	g "github.com/appsecpipeline/gasp"
+	"github.com/appsecpipeline/gasp-docker/findings"
+)
+
+func TestJUnit(t *testing.T) {
+	tool := func(name string, onFailure string) step {
+		return step{Tools: g.Tools{Tool: name, ToolProfile: "all", OnFailure: onFailure}}
+	}
+	run := &runInfo{
+		runId:        "1234",
+		name:         "stages",
+		toolProfiles: map[string]g.SecTool{"bandit": {Cmds: map[string]string{"junit": "junit.py"}}},
+		results: []*stepResult{
+			{stage: "startup", tool: tool("prep", ""), status: statusPassed, duration: 1500 * time.Millisecond},
+			{stage: "pipeline", tool: tool("bandit", ""), status: statusPassed, duration: 2 * time.Second, found: []findings.Finding{
+				{RuleID: "B403", Title: "pickle", Severity: findings.SevHigh, Location: "app/views.py", Line: 3},
+				{RuleID: "B101", Title: "assert", Severity: findings.SevInfo},
+			}},
+			{stage: "pipeline", tool: tool("scan", ""), status: statusFailed, exitCode: 2, duration: time.Second, err: errors.New("scan exited with 2")},
+			{stage: "pipeline", tool: tool("lint", "continue"), status: statusTolerated, exitCode: -1, duration: 500 * time.Millisecond,
+				err: &timeoutError{tool: "lint", limit: time.Minute}},
+			{stage: "final", tool: tool("notify", ""), status: statusSkipped},
+			{stage: "final", tool: tool("report", ""), status: statusCancelled},
+		},
+	}
+	run.global.MinSev = findings.SevLow
+
+	js := run.junit()
+	if len(js.Suites) != 3 || js.Suites[0].Name != "startup" || js.Suites[1].Name != "pipeline" || js.Suites[2].Name != "final" {
+		t.Fatalf("suites = %+v, want startup, pipeline and final", js.Suites)
+	}
+	if js.Tests != 7 || js.Failures != 3 || js.Skipped != 2 || js.Time != "5.000" {
+		t.Errorf("testsuites tests %d failures %d skipped %d time %s, want 7 3 2 5.000", js.Tests, js.Failures, js.Skipped, js.Time)
+	}
+	pipeline := js.Suites[1]
+	if pipeline.Tests != 4 || pipeline.Failures != 3 || pipeline.Skipped != 0 || pipeline.Time != "3.500" {
+		t.Errorf("pipeline tests %d failures %d skipped %d time %s, want 4 3 0 3.500", pipeline.Tests, pipeline.Failures, pipeline.Skipped, pipeline.Time)
+	}
+	if js.Suites[0].Time != "1.500" || js.Suites[2].Skipped != 2 {
+		t.Errorf("startup time %s, final skipped %d", js.Suites[0].Time, js.Suites[2].Skipped)
+	}
+
+	cases := make(map[string]junitCase)
+	for _, s := range js.Suites {
+		for _, c := range s.Cases {
+			cases[c.Name] = c
+		}
+	}
+	tests := []struct {
+		name    string
+		failure string // the failure's type, if any
+		skipped string // the skipped message, if any
+	}{
+		{"prep (all)", "", ""},
+		{"bandit (all)", "", ""},
+		// Only findings at or above min-severity get a testcase
+		{"[high] B403: pickle at app/views.py:3", "finding-high", ""},
+		{"scan (all)", "exit-code", ""},
+		{"lint (all)", "timeout", ""},
+		{"notify (all)", "", "skipped due to an earlier failure"},
+		{"report (all)", "", "cancelled"},
+	}
+	for _, tt := range tests {
+		c, ok := cases[tt.name]
+		if !ok {
+			t.Errorf("no testcase %s in %v", tt.name, cases)
+			continue
+		}
+		if (c.Failure == nil) != (tt.failure == "") || (c.Failure != nil && c.Failure.Type != tt.failure) {
+			t.Errorf("%s failure = %+v, want %q", tt.name, c.Failure, tt.failure)
+		}
+		if (c.Skipped == nil) != (tt.skipped == "") || (c.Skipped != nil && c.Skipped.Message != tt.skipped) {
+			t.Errorf("%s skipped = %+v, want %q", tt.name, c.Skipped, tt.skipped)
+		}
+	}
+	if m := cases["lint (all)"].Failure.Message; !strings.Contains(m, "tolerated by on-failure continue") {
+		t.Errorf("lint failure message = %s", m)
+	}
+	if c := cases["scan (all)"]; c.Failure.Message != "exited with 2" || c.ClassName != "stages.pipeline" {
+		t.Errorf("scan testcase = %+v", c)
+	}
+
+	// and it's valid XML
+	var buf bytes.Buffer
+	if err := run.writeJUnit(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var back junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &back); err != nil || back.Tests != 7 {
+		t.Errorf("unable to read back the JUnit XML: %v\n%s", err, buf.String())
+	}
+}
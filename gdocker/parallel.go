@@ -0,0 +1,223 @@
+// gdocker
+package gdocker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/appsecpipeline/gasp-docker/findings"
+)
+
+// stepLog buffers the output of a single tool run so concurrently run
+// tools can be logged in the order they appear in the named pipeline
+type stepLog struct {
+	console  bytes.Buffer // what would have been printed to stdout
+	detailed bytes.Buffer // what would have been written to the detailed log
+}
+
+// Write out a step's buffered output to stdout and the run's detailed log,
+// masking any secrets
+func (run *runInfo) flush(sl *stepLog) {
+	io.WriteString(os.Stdout, run.mask(sl.console.String()))
+	sl.console.Reset()
+	if run.detailed != nil {
+		io.WriteString(run.detailed, run.mask(sl.detailed.String()))
+		sl.detailed.Reset()
+	}
+}
+
+// Result of a single step of a stage
+type stepResult struct {
+	stage       string
+	tool        step
+	log         stepLog
+	status      string
+	attempts    int
+	duration    time.Duration      // how long the step ran for, including retries
+	exitCode    int                // exit code of the last attempt, -1 if the container didn't exit on its own
+	report      string             // path of the tool's resolved reportname in the containers, if it has one
+	collected   *ReportEntry       // the tool's report once it's been collected into --reports
+	found       []findings.Finding // findings read from the tool's report
+	findingsErr error              // why the findings couldn't be read from the report
+	err         error
+	after       []*stepResult // runevery steps run after this step
+	parent      *stepResult   // the pipeline step a runevery step ran after
+}
+
+// Run the steps of a stage with up to maxParallel containers at once, dynamic
+// tools are further limited to maxDynamic at once.  Steps start in order and
+// results are logged and returned in step order regardless of the order the
+// containers finish.
+// Each step's on-failure policy decides if a failure stops the stage or run.
+// Once ctx is done no further steps are started.
+func runSteps(ctx context.Context, stage string, steps map[int]step, run *runInfo, maxParallel int, maxDynamic int) []*stepResult {
+	// Anything less than 1 means no concurrency aka run one at a time
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	// No dynamic limit means the parallel limit is the only limit
+	if maxDynamic < 1 || maxDynamic > maxParallel {
+		maxDynamic = maxParallel
+	}
+
+	par := make(chan struct{}, maxParallel)
+	dyn := make(chan struct{}, maxDynamic)
+	results := make([]*stepResult, len(steps))
+	done := make([]chan struct{}, len(steps))
+	for i := range results {
+		results[i] = &stepResult{stage: stage, tool: steps[i]}
+		done[i] = make(chan struct{})
+	}
+
+	// Log each step as soon as it and every step before it has finished
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		for i := range results {
+			<-done[i]
+			run.flush(&results[i].log)
+		}
+	}()
+
+	// Set once a failure means no more steps in this stage should start
+	var stopMu sync.Mutex
+	stopped := run.isFailed()
+
+	// Steps are started in order, each waiting for a free slot
+	for i, r := range results {
+		dynamic := run.toolProfiles[r.tool.Tool].ToolType == "dynamic"
+		if dynamic {
+			dyn <- struct{}{}
+		}
+		par <- struct{}{}
+		release := func() {
+			<-par
+			if dynamic {
+				<-dyn
+			}
+		}
+
+		stopMu.Lock()
+		skip := stopped || run.isFailed()
+		stopMu.Unlock()
+		if skip || ctx.Err() != nil {
+			r.status = statusSkipped
+			if skip {
+				infoLog.Printf("Skipping %v in the %s stage due to an earlier failure", r.tool.Tool, stage)
+			} else {
+				infoLog.Printf("Skipping %v in the %s stage since the run was cancelled", r.tool.Tool, stage)
+			}
+			release()
+			close(done[i])
+			continue
+		}
+
+		go func(r *stepResult, d chan struct{}) {
+			defer close(d)
+			defer release()
+
+			runStep(ctx, r, run)
+
+			// Run any runevery tools after each pipeline tool
+			if stage == "pipeline" && len(run.runevery) > 0 && ctx.Err() == nil {
+				r.after = runEvery(ctx, r, run)
+			}
+
+			// A cancelled step stops the stage whatever its on-failure policy
+			if r.status == statusCancelled {
+				stopMu.Lock()
+				stopped = true
+				stopMu.Unlock()
+			}
+
+			// Apply the step's on-failure policy
+			if r.status == statusFailed || r.status == statusTolerated {
+				pol, _ := parsePolicy(r.tool.OnFailure)
+				if pol.action != policyContinue {
+					stopMu.Lock()
+					stopped = true
+					stopMu.Unlock()
+				}
+				if r.status == statusFailed {
+					run.setFailed()
+				}
+			}
+		}(r, done[i])
+	}
+	<-flushed
+
+	run.mu.Lock()
+	for _, r := range results {
+		run.results = append(run.results, r)
+		run.results = append(run.results, r.after...)
+	}
+	run.mu.Unlock()
+
+	return results
+}
+
+// Run a single step, retrying it if its on-failure policy is retry:N, and
+// set its status based on its on-failure policy
+func runStep(ctx context.Context, r *stepResult, run *runInfo) {
+	// --dojo imports each tool's report in-process so there's no container to run
+	if run.nativeStep(r.tool) {
+		r.status = statusPassed
+		infoLog.Printf("Not running the %v container, --dojo imports the reports in-process", r.tool.Tool)
+		fmt.Fprintf(&r.log.console, "Not running the %v container, --dojo imports the reports in-process\n", r.tool.Tool)
+		return
+	}
+
+	pol, _ := parsePolicy(r.tool.OnFailure)
+	dName := r.tool.containerName(run)
+	if run.toolProfiles[r.tool.Tool].Cmds["reportname"] != "" {
+		r.report = reportPath(run.reportName(r.tool.Tool))
+	}
+	// Collect the report and read its findings however the step ends up
+	start := time.Now()
+	defer func() {
+		r.duration = time.Since(start)
+		collectReport(r, run)
+		readFindings(r, run)
+		dojoImport(ctx, r, run)
+	}()
+
+	for r.attempts = 1; r.attempts <= pol.retries+1; r.attempts++ {
+		if r.attempts > 1 {
+			warnLog.Printf("Retrying %v, attempt %d of %d", r.tool.Tool, r.attempts, pol.retries+1)
+			fmt.Fprintf(&r.log.console, "Retrying %v, attempt %d of %d\n", r.tool.Tool, r.attempts, pol.retries+1)
+			// A kept container from the last attempt would clash with this one's name
+			if run.keep {
+				run.rt.RemoveContainer(dName)
+			}
+		}
+
+		infoLog.Printf("Launching container for %v", r.tool.Tool)
+		r.exitCode, r.err = launchContainer(ctx, r.tool, run, &r.log)
+		if r.err == nil {
+			r.status = statusPassed
+			return
+		}
+		// No retries or on-failure policy for a cancelled run
+		if ctx.Err() != nil {
+			r.status = statusCancelled
+			warnLog.Printf("Step %v was cancelled on attempt %d", r.tool.Tool, r.attempts)
+			return
+		}
+		warnLog.Printf("Step %v failed on attempt %d: %s", r.tool.Tool, r.attempts, r.err)
+	}
+	r.attempts--
+
+	switch pol.action {
+	case policyContinue, policySkipStage:
+		r.status = statusTolerated
+		warnLog.Printf("Step %v failed but on-failure is %s, run continues", r.tool.Tool, pol.action)
+	default:
+		r.status = statusFailed
+		errorLog.Printf("Step %v failed and on-failure is %s, run has failed", r.tool.Tool, r.tool.OnFailure)
+	}
+}
@@ -0,0 +1,208 @@
+// gdocker
+package gdocker
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStepsLimits(t *testing.T) {
+	r, f, done := newTestRunner(t)
+	defer done()
+	r.Args.Profile = "parallel"
+	id := planTestRun(t, r)
+	for _, tool := range []string{"scan", "probe", "lint", "crawl"} {
+		f.Delay[tool+"_"+id] = 50 * time.Millisecond
+	}
+
+	res, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.ExitCode != ExitSuccess {
+		t.Errorf("ExitCode = %d, want %d", res.ExitCode, ExitSuccess)
+	}
+
+	// testdata's master.yaml has max-parallel 2 and max-dynamic 1, probe and
+	// crawl are the dynamic tools
+	tools := map[string]bool{"scan_" + id: false, "lint_" + id: false, "probe_" + id: true, "crawl_" + id: true}
+	peak, peakDynamic := 0, 0
+	for _, running := range f.Concurrent {
+		n, dyn := 0, 0
+		for _, name := range running {
+			dynamic, ok := tools[name]
+			if !ok {
+				continue
+			}
+			n++
+			if dynamic {
+				dyn++
+			}
+		}
+		if n > peak {
+			peak = n
+		}
+		if dyn > peakDynamic {
+			peakDynamic = dyn
+		}
+	}
+	if peak != 2 || peakDynamic != 1 {
+		t.Errorf("at most %d tools and %d dynamic tools ran at once, want 2 and 1: %v", peak, peakDynamic, f.Concurrent)
+	}
+}
+
+func TestRunStepsFlushOrder(t *testing.T) {
+	r, f, done := newTestRunner(t)
+	defer done()
+	id := planTestRun(t, r)
+	// scan is slow so lint, the step after it, finishes first
+	f.Delay["scan_"+id] = 100 * time.Millisecond
+	f.Output["scan_"+id] = "output from scan\n"
+	f.Output["lint_"+id] = "output from lint\n"
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// scan either hadn't started or was still running when lint started
+	scanRun := false
+	for i, s := range f.Runs {
+		scanRun = scanRun || s.Name == "scan_"+id
+		if s.Name == "lint_"+id && scanRun && !contains(f.Concurrent[i], "scan_"+id) {
+			t.Errorf("lint started once scan had finished: %v", f.Concurrent[i])
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(r.LogDir, id+"_detailed.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	scan, lint := strings.Index(log, "output from scan"), strings.Index(log, "output from lint")
+	if scan < 0 || lint < 0 || scan > lint {
+		t.Errorf("detailed log has scan's output at %d and lint's at %d, want scan first:\n%s", scan, lint, log)
+	}
+}
+
+func TestRunStepTimeout(t *testing.T) {
+	defer func(u time.Duration) { maxToolRunUnit = u }(maxToolRunUnit)
+	maxToolRunUnit = 10 * time.Millisecond
+
+	r, f, done := newTestRunner(t)
+	defer done()
+	id := planTestRun(t, r)
+	f.Hang["scan_"+id] = true
+
+	res, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.ExitCode != ExitFailed || res.Cancelled {
+		t.Errorf("ExitCode = %d and Cancelled = %v, want %d and false", res.ExitCode, res.Cancelled, ExitFailed)
+	}
+	for _, s := range res.Steps {
+		if s.Tool == "scan" && (s.Status != statusFailed || s.ExitCode != -1) {
+			t.Errorf("scan status = %s, exit code %d, want %s and -1", s.Status, s.ExitCode, statusFailed)
+		}
+	}
+	// The hung container is stopped and removed
+	if !contains(f.Killed, "scan_"+id) {
+		t.Errorf("Killed = %v, want scan_%s", f.Killed, id)
+	}
+	if _, ok := f.Containers["scan_"+id]; ok {
+		t.Errorf("scan_%s wasn't removed", id)
+	}
+}
+
+// Each step's result by stage and tool e.g. pipeline/scan
+func stepsByName(res *RunResult) map[string]StepResult {
+	steps := make(map[string]StepResult)
+	for _, s := range res.Steps {
+		steps[s.Stage+"/"+s.Tool] = s
+	}
+	return steps
+}
+
+// The number of times a container was run
+func runCount(f *FakeRuntime, name string) int {
+	n := 0
+	for _, s := range f.Runs {
+		if s.Name == name {
+			n++
+		}
+	}
+	return n
+}
+
+func TestOnFailureTolerated(t *testing.T) {
+	r, f, done := newTestRunner(t)
+	defer done()
+	r.Args.Profile = "policies"
+	id := planTestRun(t, r)
+	f.ExitCodes["prep_"+id] = 1
+	f.ExitCodes["lint_"+id] = 1
+
+	res, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.ExitCode != ExitTolerated {
+		t.Errorf("ExitCode = %d, want %d", res.ExitCode, ExitTolerated)
+	}
+
+	// skip-stage skips the rest of startup but not the stages after it, and
+	// continue carries on with the rest of final
+	want := map[string]string{
+		"startup/prep":   statusTolerated,
+		"startup/notify": statusSkipped,
+		"pipeline/scan":  statusPassed,
+		"final/lint":     statusTolerated,
+		"final/probe":    statusPassed,
+	}
+	steps := stepsByName(res)
+	for name, status := range want {
+		if steps[name].Status != status {
+			t.Errorf("%s status = %s, want %s", name, steps[name].Status, status)
+		}
+	}
+	if contains(runNames(f), "notify_"+id) || !contains(runNames(f), "probe_"+id) {
+		t.Errorf("containers run = %v, want probe but not notify", runNames(f))
+	}
+}
+
+func TestOnFailureRetry(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		r, f, done := newTestRunner(t)
+		r.Args.Profile = "policies"
+		r.Args.Keep = keep
+		id := planTestRun(t, r)
+		f.ExitCodes["scan_"+id] = 3
+
+		res, err := r.Run(context.Background())
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if res.ExitCode != ExitFailed {
+			t.Errorf("keep %v: ExitCode = %d, want %d", keep, res.ExitCode, ExitFailed)
+		}
+
+		// retry:2 is 3 attempts, a kept container is removed before each retry
+		// so every attempt gets to run and exit with 3
+		scan := stepsByName(res)["pipeline/scan"]
+		if scan.Status != statusFailed || scan.Attempts != 3 || scan.ExitCode != 3 {
+			t.Errorf("keep %v: scan status %s, %d attempts, exit code %d, want %s, 3 and 3",
+				keep, scan.Status, scan.Attempts, scan.ExitCode, statusFailed)
+		}
+		if n := runCount(f, "scan_"+id); n != 3 {
+			t.Errorf("keep %v: scan was run %d times, want 3", keep, n)
+		}
+		// and the failure stops the run
+		if s := stepsByName(res)["final/lint"].Status; s != statusSkipped {
+			t.Errorf("keep %v: lint status = %s, want %s", keep, s, statusSkipped)
+		}
+		done()
+	}
+}
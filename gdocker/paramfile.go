@@ -0,0 +1,237 @@
+// gdocker
+package gdocker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Prefix of environment variables which set a tool parameter e.g.
+// GASP_PARAM_LOC=/src sets LOC
+const paramEnvPrefix = "GASP_PARAM_"
+
+// Work out the parameters for a run from all of their sources, later sources
+// winning over earlier ones:
+//
+//	defaults   LOC from --location for tools with LOC, then params in
+//	           master.yaml, [app-name]-pipeline.yaml and the named pipeline's
+//	           profile
+//	file       --params-file, then --secrets-file
+//	env        config parameters by name e.g. DOJO_API_KEY, then GASP_PARAM_*
+//	--params   the command-line
+func resolveParams(run *runInfo, mc *masterConf, sent paramSet) (paramSet, error) {
+	ps := locDefaults(run)
+	mps, err := paramsFromValues(mc.Params, "master.yaml")
+	if err != nil {
+		return ps, fmt.Errorf("unable to use the params in master.yaml: %v", err)
+	}
+	ps.merge(mps)
+	aps, err := paramsFromValues(mc.appParams, mc.appFile)
+	if err != nil {
+		return ps, fmt.Errorf("unable to use the params in %s: %v", mc.appFile, err)
+	}
+	ps.merge(aps)
+	pf := "master.yaml"
+	if f, ok := mc.profFrom[run.name]; ok {
+		pf = f
+	}
+	pps, err := paramsFromValues(mc.Prof[run.name].Params, pf+" "+run.name)
+	if err != nil {
+		return ps, fmt.Errorf("unable to use the params for %s in %s: %v", run.name, pf, err)
+	}
+	ps.merge(pps)
+
+	if run.paramsFile != "" {
+		fps, err := readParamsFile(run.paramsFile)
+		if err != nil {
+			return ps, fmt.Errorf("unable to read the params file: %v", err)
+		}
+		infoLog.Printf("Read parameters from %s", run.paramsFile)
+		ps.merge(fps)
+	}
+	if run.secretsFile != "" {
+		fps, err := readEnvFile(run.secretsFile)
+		if err != nil {
+			return ps, fmt.Errorf("unable to read the secrets file: %v", err)
+		}
+		infoLog.Printf("Read secrets from %s", run.secretsFile)
+		ps.merge(fps)
+	}
+
+	ps.merge(configFromEnv(run.toolProfiles))
+	eps, err := paramsFromEnv(os.Environ())
+	if err != nil {
+		return ps, err
+	}
+	ps.merge(eps)
+
+	warnSecretParams(run, sent)
+	ps.merge(sent)
+
+	return ps, nil
+}
+
+// Tools are pointed at the source code with their LOC parameter so it
+// defaults to --location for every tool which declares it, whatever its type
+// e.g. git and cloc in the sourcecode profile's startup stage
+func locDefaults(run *runInfo) paramSet {
+	ps := newParamSet("--location")
+	for name, t := range run.toolProfiles {
+		if _, ok := t.Parameters["LOC"]; ok {
+			ps.set(name+".LOC", run.loc)
+		}
+	}
+	return ps
+}
+
+// Read a --params-file - YAML or JSON for .yaml, .yml and .json files,
+// anything else is read as a .env file of NAME=value lines
+func readParamsFile(file string) (paramSet, error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return readEnvFile(file)
+	}
+
+	f, err := ioutil.ReadFile(file)
+	if err != nil {
+		return newParamSet(file), err
+	}
+	// JSON is valid YAML so one parser does for both
+	vals := make(map[string]interface{})
+	if err := yaml.Unmarshal(f, &vals); err != nil {
+		return newParamSet(file), fmt.Errorf("unable to parse %s: %v", file, err)
+	}
+	ps, err := paramsFromValues(vals, file)
+	if err != nil {
+		return ps, fmt.Errorf("%s: %v", file, err)
+	}
+	return ps, nil
+}
+
+// Parameters from a YAML or JSON map where each key is NAME or tool.NAME, or
+// a tool with a map of its own parameters e.g.
+//
+//	DOJO_HOST: https://dojo.example.com
+//	bandit:
+//	  LOC: /opt/appsecpipeline/source/app
+func paramsFromValues(vals map[string]interface{}, source string) (paramSet, error) {
+	ps := newParamSet(source)
+	for k, v := range vals {
+		tool, ok := v.(map[interface{}]interface{})
+		if !ok {
+			if err := setValue(ps, k, v); err != nil {
+				return ps, err
+			}
+			continue
+		}
+		for tk, tv := range tool {
+			if err := setValue(ps, k+"."+fmt.Sprint(tk), tv); err != nil {
+				return ps, err
+			}
+		}
+	}
+	return ps, nil
+}
+
+// Set a parameter from a YAML value, which must be a string, number or bool
+func setValue(ps paramSet, key string, v interface{}) error {
+	switch v.(type) {
+	case nil:
+		return ps.set(key, "")
+	case string, int, int64, uint64, float64, bool:
+		return ps.set(key, fmt.Sprint(v))
+	}
+	return fmt.Errorf("%s must be a string, number or true/false", key)
+}
+
+// Read a NAME=value file e.g. a secrets file or .env file.  Blank lines and
+// lines starting with # are skipped, values can be quoted and names can be
+// scoped to a tool like --params e.g. bandit.LOC=/src
+func readEnvFile(file string) (paramSet, error) {
+	ps := newParamSet(file)
+
+	f, err := os.Open(file)
+	if err != nil {
+		return ps, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return ps, fmt.Errorf("%s line %d isn't NAME=value", file, n)
+		}
+		val := strings.TrimSpace(kv[1])
+		if len(val) > 1 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+		if err := ps.set(strings.TrimSpace(kv[0]), val); err != nil {
+			return ps, fmt.Errorf("%s line %d: %v", file, n, err)
+		}
+	}
+
+	return ps, scanner.Err()
+}
+
+// Parameters from GASP_PARAM_NAME=value environment variables, which are
+// sent to every tool with a NAME parameter
+func paramsFromEnv(environ []string) (paramSet, error) {
+	ps := newParamSet("env")
+	for _, e := range environ {
+		if !strings.HasPrefix(e, paramEnvPrefix) {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(e, paramEnvPrefix), "=", 2)
+		if len(kv) != 2 || ps.set(kv[0], kv[1]) != nil {
+			return ps, fmt.Errorf("%s%s isn't a valid parameter name", paramEnvPrefix, kv[0])
+		}
+	}
+	return ps, nil
+}
+
+// Write out the parameters each tool in the run will get and where each
+// came from, with secrets masked e.g. for --show-params
+func (run *runInfo) writeParams(w io.Writer) {
+	tools := make([]string, 0, len(run.sentParams))
+	for t := range run.sentParams {
+		tools = append(tools, t)
+	}
+	sort.Strings(tools)
+
+	fmt.Fprintf(w, "Parameters for the %s named pipeline\n", run.name)
+	for _, t := range tools {
+		fmt.Fprintf(w, "  %s\n", t)
+		names := make([]string, 0, len(run.sentParams[t]))
+		for n := range run.sentParams[t] {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			fmt.Fprintf(w, "    (none)\n")
+		}
+		for _, n := range names {
+			v := run.mask(run.sentParams[t][n])
+			if run.isSecretParam(t, n) {
+				v = "********"
+			}
+			fmt.Fprintf(w, "    %-24s %-40s %s\n", n, v, run.params.sourceOf(t, n))
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// gdocker
+package gdocker
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadParamsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gasp-docker-params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	global := map[string]string{"DOJO_HOST": "https://dojo.example.com", "DOJO_PRODUCT_ID": "7", "VERIFY": "false"}
+	scoped := map[string]map[string]string{"bandit": {"LOC": "/src/app"}, "nmap": {"TARGET": "10.0.0.1"}}
+	files := map[string]string{
+		"params.yaml": "DOJO_HOST: https://dojo.example.com\nDOJO_PRODUCT_ID: 7\nVERIFY: false\n" +
+			"bandit:\n  LOC: /src/app\nnmap.TARGET: 10.0.0.1\n",
+		"params.json": `{"DOJO_HOST": "https://dojo.example.com", "DOJO_PRODUCT_ID": 7, "VERIFY": false,` +
+			` "bandit": {"LOC": "/src/app"}, "nmap.TARGET": "10.0.0.1"}`,
+		"params.env": "# DefectDojo\nDOJO_HOST=https://dojo.example.com\n\nexport DOJO_PRODUCT_ID=7\nVERIFY='false'\n" +
+			"bandit.LOC=\"/src/app\"\nnmap.TARGET = 10.0.0.1\n",
+	}
+	for name, data := range files {
+		file := writeTestFile(t, dir, name, data)
+		ps, err := readParamsFile(file)
+		if err != nil || !reflect.DeepEqual(ps.global, global) || !reflect.DeepEqual(ps.scoped, scoped) {
+			t.Errorf("%s: read %v %v, %v, want %v %v", name, ps.global, ps.scoped, err, global, scoped)
+			continue
+		}
+		if src := ps.sourceOf("bandit", "LOC"); src != file {
+			t.Errorf("%s: bandit LOC is from %s", name, src)
+		}
+	}
+
+	bad := map[string]string{
+		"list.yaml":   "- LOC\n",
+		"nested.yaml": "bandit:\n  LOC: [/src]\n",
+		"broken.json": `{"LOC": `,
+		"name.env":    "1LOC=/src\n",
+	}
+	for name, data := range bad {
+		if _, err := readParamsFile(writeTestFile(t, dir, name, data)); err == nil {
+			t.Errorf("%s: read without an error", name)
+		}
+	}
+}
+
+func TestReadSecretsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gasp-docker-params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ps, err := readEnvFile(writeTestFile(t, dir, "secrets", "DOJO_API_KEY=s3cret\n# a comment\nCHECKMARX_PASSWORD='p=w d'\n"))
+	want := map[string]string{"DOJO_API_KEY": "s3cret", "CHECKMARX_PASSWORD": "p=w d"}
+	if err != nil || !reflect.DeepEqual(ps.global, want) {
+		t.Errorf("readEnvFile = %v, %v, want %v", ps.global, err, want)
+	}
+
+	// A malformed line is reported with its line number
+	_, err = readEnvFile(writeTestFile(t, dir, "bad", "DOJO_API_KEY=s3cret\n\nDOJO_HOST\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3 isn't NAME=value") {
+		t.Errorf("readEnvFile with a malformed line returned %v", err)
+	}
+
+	if _, err := readEnvFile(dir + "/nosuch"); err == nil {
+		t.Errorf("readEnvFile of a missing file didn't return an error")
+	}
+}
+
+func TestParamsFromEnv(t *testing.T) {
+	ps, err := paramsFromEnv([]string{"HOME=/root", paramEnvPrefix + "LOC=/src", paramEnvPrefix + "bandit.LOC=/src/app"})
+	if err != nil || ps.global["LOC"] != "/src" || ps.scoped["bandit"]["LOC"] != "/src/app" || len(ps.global) != 1 {
+		t.Errorf("paramsFromEnv = %v %v, %v", ps.global, ps.scoped, err)
+	}
+	if _, err := paramsFromEnv([]string{paramEnvPrefix + "=x"}); err == nil {
+		t.Errorf("paramsFromEnv with no name didn't return an error")
+	}
+}
@@ -0,0 +1,279 @@
+// gdocker
+package gdocker
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	g "github.com/appsecpipeline/gasp"
+)
+
+// Hostnames are dot separated labels of letters, digits and -
+var hostRe = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*\.?$`)
+
+// scp style git URLs e.g. git@github.com:owner/repo.git
+var scpRe = regexp.MustCompile(`^[a-zA-Z0-9._-]+@[a-zA-Z0-9.-]+:.+$`)
+
+// Check a parameter's value against its data_type from secpipeline-config.yaml
+func validateParam(dataType string, value string) error {
+	switch strings.ToLower(dataType) {
+	case "", "string":
+		// Anything goes, even empty
+		return nil
+	case "url":
+		if scpRe.MatchString(value) {
+			return nil
+		}
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("'%s' is not a valid URL e.g. https://example.com/", value)
+		}
+	case "int":
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("'%s' is not a whole number", value)
+		}
+	case "bool":
+		switch strings.ToLower(value) {
+		case "true", "false", "yes", "no", "1", "0":
+		default:
+			return fmt.Errorf("'%s' is not true or false", value)
+		}
+	case "host":
+		if !validHost(value) {
+			return fmt.Errorf("'%s' is not a valid hostname or IP address", value)
+		}
+	case "key", "api", "username":
+		if value == "" || strings.ContainsAny(value, " \t\r\n") {
+			return fmt.Errorf("a %s can't be empty or contain spaces", dataType)
+		}
+	case "password":
+		if value == "" {
+			return fmt.Errorf("a password can't be empty")
+		}
+	default:
+		warnLog.Printf("Unknown data_type '%s', not validating its value", dataType)
+	}
+	return nil
+}
+
+// A hostname or IP address, optionally with a :port
+func validHost(h string) bool {
+	if host, port, err := net.SplitHostPort(h); err == nil {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return false
+		}
+		h = host
+	}
+	if net.ParseIP(h) != nil {
+		return true
+	}
+	return len(h) <= 253 && hostRe.MatchString(h)
+}
+
+// Check every parameter sent for the tools in this run against its data_type
+// and that every parameter used by a step's command was sent.  All problems
+// are reported at once so they can be fixed in one go.
+func verifyParams(run *runInfo) error {
+	problems := make(map[string]bool)
+
+	for _, st := range run.stages() {
+		for i := 0; i < len(st.steps); i++ {
+			s := st.steps[i]
+			params := run.toolProfiles[s.Tool].Parameters
+			vars := run.toolVars(s)
+
+			// Values sent must match their data_type
+			for name, val := range vars {
+				pm, ok := params[name]
+				if !ok {
+					continue
+				}
+				if err := validateParam(pm.DataType, val); err != nil {
+					problems[fmt.Sprintf("%s: parameter %s (%s): %v", s.Tool, name, pm.DataType, err)] = true
+				}
+			}
+
+			// Parameters used by the step's command must be sent, unless --dojo
+			// does the step in-process
+			if run.nativeStep(s) {
+				continue
+			}
+			if _, err := genToolCmd(s, run); err != nil {
+				ue, ok := err.(*unresolvedErr)
+				if !ok {
+					continue
+				}
+				for _, n := range ue.names {
+					if !strings.HasPrefix(n, "$") {
+						continue
+					}
+					name := strings.Trim(n, "${}")
+					if pm, ok := params[name]; ok {
+						problems[fmt.Sprintf("%s: parameter %s (%s) is used by the '%s' tool-profile but wasn't sent - %s",
+							s.Tool, name, pm.DataType, s.ToolProfile, pm.Desc)] = true
+					}
+				}
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	list := make([]string, 0, len(problems))
+	for p := range problems {
+		list = append(list, p)
+		warnLog.Printf("Parameter problem: %s", p)
+	}
+	sort.Strings(list)
+	return fmt.Errorf("invalid or missing tool parameters:\n  %s", strings.Join(list, "\n  "))
+}
+
+// Parameters sent for a run before they're matched up with its tools
+type paramSet struct {
+	global map[string]string            // NAME=value for every tool with a NAME parameter
+	scoped map[string]map[string]string // tool.NAME=value for just that tool
+	source string                       // where parameters set in this set came from e.g. --params
+	from   map[string]string            // where each NAME or tool.NAME came from
+}
+
+// Parse --params which is a list of NAME=value or tool.NAME=value entries
+// separated by spaces.  Names must match exactly, everything after the first
+// = is the value and values with spaces can be quoted like a shell e.g.
+//
+//	LOC=/src bandit.LOC=/src/app nmap.TARGET=10.0.0.1 LOGIN_PARMS='username=user&password=pw'
+func parseParams(raw string) (paramSet, error) {
+	ps := newParamSet("--params")
+
+	entries, err := splitCommand(raw)
+	if err != nil {
+		return ps, fmt.Errorf("unable to parse --params: %v", err)
+	}
+
+	bad := make([]string, 0)
+	for _, e := range entries {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 || ps.set(kv[0], kv[1]) != nil {
+			bad = append(bad, e)
+		}
+	}
+
+	if len(bad) > 0 {
+		return ps, fmt.Errorf("--params entries must be NAME=value or tool.NAME=value, unable to use: %s", strings.Join(bad, ", "))
+	}
+	return ps, nil
+}
+
+func newParamSet(source string) paramSet {
+	return paramSet{
+		global: make(map[string]string),
+		scoped: make(map[string]map[string]string),
+		source: source,
+		from:   make(map[string]string),
+	}
+}
+
+// Set NAME or tool.NAME to val
+func (ps paramSet) set(key string, val string) error {
+	// A . scopes the parameter to a tool, tool names can have - e.g. dependency-check
+	tool := ""
+	if d := strings.LastIndex(key, "."); d >= 0 {
+		tool, key = key[:d], key[d+1:]
+		if tool == "" {
+			return fmt.Errorf("no tool before the . in .%s", key)
+		}
+	}
+	if !validName(key) {
+		return fmt.Errorf("'%s' isn't a valid parameter name", key)
+	}
+
+	if tool == "" {
+		ps.global[key] = val
+		ps.from[key] = ps.source
+		return nil
+	}
+	if ps.scoped[tool] == nil {
+		ps.scoped[tool] = make(map[string]string)
+	}
+	ps.scoped[tool][key] = val
+	ps.from[tool+"."+key] = ps.source
+	return nil
+}
+
+// Merge over on top of ps, over wins for any parameter set in both.  A
+// global parameter in over replaces the same parameter scoped to a tool in ps
+// so a lower precedence source can't beat it.
+func (ps paramSet) merge(over paramSet) {
+	for k, v := range over.global {
+		ps.global[k] = v
+		ps.from[k] = over.from[k]
+		for tool, sc := range ps.scoped {
+			delete(sc, k)
+			delete(ps.from, tool+"."+k)
+		}
+	}
+	for tool, params := range over.scoped {
+		for k, v := range params {
+			ps.set(tool+"."+k, v)
+			ps.from[tool+"."+k] = over.from[tool+"."+k]
+		}
+	}
+}
+
+// Where the value of a tool's parameter came from
+func (ps paramSet) sourceOf(tool string, name string) string {
+	if src, ok := ps.from[tool+"."+name]; ok {
+		return src
+	}
+	return ps.from[name]
+}
+
+// A parameter name is shell style e.g. DOJO_API_KEY
+func validName(n string) bool {
+	if n == "" || !isNameStart(n[0]) {
+		return false
+	}
+	for i := 0; i < len(n); i++ {
+		if !isNameChar(n[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Match the sent parameters up with each tool's parameters from
+// secpipeline-config.yaml.  A tool gets any global parameter it declares
+// plus every parameter scoped to it, scoped values overriding global ones.
+func (ps paramSet) forTools(tools map[string]g.SecTool) map[string]map[string]string {
+	sent := make(map[string]map[string]string)
+	for name, t := range tools {
+		vals := make(map[string]string)
+		for p := range t.Parameters {
+			if v, ok := ps.global[p]; ok {
+				vals[p] = v
+			}
+		}
+		for p, v := range ps.scoped[name] {
+			if _, ok := t.Parameters[p]; !ok {
+				infoLog.Printf("Parameter %s was scoped to %s which doesn't declare it, passing it anyway", p, name)
+			}
+			vals[p] = v
+		}
+		sent[name] = vals
+	}
+
+	// A scope for a tool not in this run is most likely a typo
+	for name := range ps.scoped {
+		if _, ok := tools[name]; !ok {
+			warnLog.Printf("Parameters were scoped to %s which isn't in this run", name)
+			fmt.Printf("WARNING: --params has parameters for %s which isn't in this run\n", name)
+		}
+	}
+
+	return sent
+}
@@ -0,0 +1,178 @@
+// gdocker
+package gdocker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	g "github.com/appsecpipeline/gasp"
+)
+
+// Write a file for a test into dir, returning its path
+func writeTestFile(t *testing.T, dir string, name string, data string) string {
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		in     string
+		global map[string]string
+		scoped map[string]map[string]string
+	}{
+		{"LOC=/src", map[string]string{"LOC": "/src"}, map[string]map[string]string{}},
+		// Everything after the first = is the value
+		{"A=b=c EMPTY=", map[string]string{"A": "b=c", "EMPTY": ""}, map[string]map[string]string{}},
+		{"LOC=/src bandit.LOC=/src/app dependency-check.LOC=/lib",
+			map[string]string{"LOC": "/src"},
+			map[string]map[string]string{"bandit": {"LOC": "/src/app"}, "dependency-check": {"LOC": "/lib"}}},
+		{`LOGIN_PARMS='username=user&password=pw' MSG="two words"`,
+			map[string]string{"LOGIN_PARMS": "username=user&password=pw", "MSG": "two words"}, map[string]map[string]string{}},
+	}
+	for _, tt := range tests {
+		ps, err := parseParams(tt.in)
+		if err != nil || !reflect.DeepEqual(ps.global, tt.global) || !reflect.DeepEqual(ps.scoped, tt.scoped) {
+			t.Errorf("parseParams(%q) = %v %v, %v, want %v %v", tt.in, ps.global, ps.scoped, err, tt.global, tt.scoped)
+		}
+	}
+
+	for _, in := range []string{"LOC", "1LOC=x", "bad-name=x", ".LOC=x", "bandit.=x", "LOC='/src"} {
+		if _, err := parseParams(in); err == nil {
+			t.Errorf("parseParams(%q) didn't return an error", in)
+		}
+	}
+}
+
+func TestValidateParam(t *testing.T) {
+	tests := []struct {
+		dataType string
+		value    string
+		ok       bool
+	}{
+		{"", "anything at all", true},
+		{"string", "", true},
+		{"url", "https://example.com/app.git", true},
+		{"url", "git@github.com:owner/repo.git", true},
+		{"url", "example.com", false},
+		{"int", "42", true},
+		{"int", "4.2", false},
+		{"bool", "Yes", true},
+		{"bool", "maybe", false},
+		{"host", "scanme.nmap.org", true},
+		{"host", "10.0.0.1:8080", true},
+		{"host", "[::1]:443", true},
+		{"host", "10.0.0.1:99999", false},
+		{"host", "not a host", false},
+		{"key", "abc123", true},
+		{"api", "", false},
+		{"username", "first last", false},
+		{"password", "pass word", true},
+		{"password", "", false},
+		{"nosuch", "", true},
+	}
+	for _, tt := range tests {
+		if err := validateParam(tt.dataType, tt.value); (err == nil) != tt.ok {
+			t.Errorf("validateParam(%q, %q) = %v, want ok %v", tt.dataType, tt.value, err, tt.ok)
+		}
+	}
+}
+
+// Set an environment variable for a test, call the returned func to put it back
+func setTestEnv(name string, val string) func() {
+	old, had := os.LookupEnv(name)
+	os.Setenv(name, val)
+	return func() {
+		if had {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestResolveParamsPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gasp-docker-params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	paramsFile := writeTestFile(t, dir, "params.yaml", "LOC: /params-file\n")
+	secretsFile := writeTestFile(t, dir, "secrets.env", "LOC=/secrets-file\n")
+
+	// Each source in order of precedence, each one is added on top of
+	// those before it and should win over them
+	levels := []struct {
+		source string
+		add    func(run *runInfo, mc *masterConf, sent *paramSet) func()
+	}{
+		{"--location", func(run *runInfo, mc *masterConf, sent *paramSet) func() {
+			run.loc = "/--location"
+			return func() {}
+		}},
+		{"master.yaml", func(run *runInfo, mc *masterConf, sent *paramSet) func() {
+			mc.Params = map[string]interface{}{"LOC": "/master.yaml"}
+			return func() {}
+		}},
+		{"testapp-pipeline.yaml", func(run *runInfo, mc *masterConf, sent *paramSet) func() {
+			mc.appParams = map[string]interface{}{"LOC": "/testapp-pipeline.yaml"}
+			mc.appFile = "testapp-pipeline.yaml"
+			return func() {}
+		}},
+		{"master.yaml stages", func(run *runInfo, mc *masterConf, sent *paramSet) func() {
+			mc.Prof = map[string]profileConf{"stages": {Params: map[string]interface{}{"LOC": "/master.yaml stages"}}}
+			return func() {}
+		}},
+		{paramsFile, func(run *runInfo, mc *masterConf, sent *paramSet) func() {
+			run.paramsFile = paramsFile
+			return func() {}
+		}},
+		{secretsFile, func(run *runInfo, mc *masterConf, sent *paramSet) func() {
+			run.secretsFile = secretsFile
+			return func() {}
+		}},
+		{"env", func(run *runInfo, mc *masterConf, sent *paramSet) func() {
+			return setTestEnv("LOC", "/env")
+		}},
+		{"env", func(run *runInfo, mc *masterConf, sent *paramSet) func() {
+			return setTestEnv(paramEnvPrefix+"LOC", "/GASP_PARAM_LOC")
+		}},
+		{"--params", func(run *runInfo, mc *masterConf, sent *paramSet) func() {
+			*sent, _ = parseParams("LOC=/--params")
+			return func() {}
+		}},
+	}
+	want := []string{"/--location", "/master.yaml", "/testapp-pipeline.yaml", "/master.yaml stages",
+		"/params-file", "/secrets-file", "/env", "/GASP_PARAM_LOC", "/--params"}
+
+	for i := range levels {
+		run := &runInfo{
+			name:         "stages",
+			toolProfiles: map[string]g.SecTool{"scan": {Parameters: map[string]g.PMeta{"LOC": {PType: "config"}}}},
+		}
+		mc := &masterConf{}
+		sent := newParamSet("--params")
+		undo := make([]func(), 0)
+		for _, l := range levels[:i+1] {
+			undo = append(undo, l.add(run, mc, &sent))
+		}
+
+		ps, err := resolveParams(run, mc, sent)
+		for _, u := range undo {
+			u()
+		}
+		if err != nil {
+			t.Errorf("%s: resolveParams failed: %v", levels[i].source, err)
+			continue
+		}
+		got := ps.forTools(run.toolProfiles)["scan"]["LOC"]
+		if got != want[i] || ps.sourceOf("scan", "LOC") != levels[i].source {
+			t.Errorf("with %s LOC = %s from %s, want %s", levels[i].source, got, ps.sourceOf("scan", "LOC"), want[i])
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// gdocker
+package gdocker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Tools write their reports to the reports directory of the data volume,
+// {reportname} is the report's full path there
+const reportDir = "/opt/appsecpipeline/reports"
+
+// Name of the manifest of collected reports in a run's reports directory
+const reportIndex = "index.json"
+
+// ReportEntry is a tool's report collected into the --reports directory
+type ReportEntry struct {
+	Stage       string `json:"stage"`
+	Tool        string `json:"tool"`
+	ToolProfile string `json:"tool-profile"`
+	Path        string `json:"path"` // relative to the run's reports directory e.g. pipeline/2_bandit/1234.json
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	ExitCode    int    `json:"exit-code"`       // exit code of the tool's last attempt
+	Error       string `json:"error,omitempty"` // why the report couldn't be collected
+}
+
+// The directory a run's reports are collected into - [reports]/[run id]
+func (run *runInfo) reportsDir() string {
+	return filepath.Join(run.Rpt, run.runId)
+}
+
+// Check if reports should be collected for this run
+func (run *runInfo) collecting() bool {
+	return run.Rpt != "" && run.Rpt != "none" && !run.dryRun
+}
+
+// Path of a report inside the containers
+func reportPath(name string) string {
+	if path.IsAbs(name) {
+		return path.Clean(name)
+	}
+	return path.Join(reportDir, name)
+}
+
+// Where a step's report is collected to in the run's reports directory -
+// [stage]/[n]_[tool] so a tool run in more than one step or stage doesn't
+// overwrite its other reports.  runevery steps go under the pipeline step
+// they ran after.
+func (r *stepResult) reportKey() string {
+	key := path.Join(r.stage, fmt.Sprintf("%d_%s", r.tool.index+1, r.tool.Tool))
+	if r.parent != nil {
+		key = path.Join(r.parent.reportKey(), key)
+	}
+	return key
+}
+
+// Copy a step's report out of the data volume into
+// [reports]/[run id]/[stage]/[n]_[tool]/ once the step has finished
+func collectReport(r *stepResult, run *runInfo) {
+	if !run.collecting() || r.report == "" {
+		return
+	}
+	if r.status == statusCancelled || r.status == statusSkipped {
+		return
+	}
+
+	e := &ReportEntry{
+		Stage:       r.stage,
+		Tool:        r.tool.Tool,
+		ToolProfile: r.tool.ToolProfile,
+		Path:        path.Join(r.reportKey(), path.Base(r.report)),
+		ExitCode:    r.exitCode,
+	}
+	r.collected = e
+
+	dst := filepath.Join(run.reportsDir(), filepath.FromSlash(e.Path))
+	err := copyReport(r.report, filepath.Dir(dst), r, run)
+	if err == nil {
+		e.Size, e.SHA256, err = fileSum(dst)
+	}
+	if err != nil {
+		warnLog.Printf("Unable to collect report %s for %s, error was: %s", r.report, r.tool.Tool, err)
+		fmt.Fprintf(&r.log.console, "Unable to collect report %s for %s: %s\n", r.report, r.tool.Tool, err)
+		e.Error = err.Error()
+		return
+	}
+
+	infoLog.Printf("Collected report %s for %s to %s", r.report, r.tool.Tool, e.Path)
+	fmt.Fprintf(&r.log.console, "Collected report %s\n", dst)
+}
+
+// Copy a file a step wrote e.g. its report into the local directory dir,
+// straight from the run's local volume directory or, for an ephemeral data
+// volume, out of a throwaway container with the volume mounted
+func copyReport(name string, dir string, r *stepResult, run *runInfo) error {
+	p := reportPath(name)
+	if !strings.HasPrefix(p, "/opt/appsecpipeline/") {
+		return fmt.Errorf("%s isn't on the data volume at /opt/appsecpipeline", p)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if run.Vol != "none" {
+		src := filepath.Join(run.Vol, filepath.FromSlash(strings.TrimPrefix(p, "/opt/appsecpipeline/")))
+		return copyFile(src, filepath.Join(dir, path.Base(p)))
+	}
+
+	// The container just has to exist to copy out of it, it doesn't do anything
+	spec := ContainerSpec{
+		Name:       "report_" + r.tool.containerName(run),
+		Image:      helperImage,
+		Entrypoint: "true",
+		Mounts:     []Mount{{Source: run.dataVol, Target: "/opt/appsecpipeline/"}},
+	}
+	if _, err := run.rt.RunContainer(context.Background(), spec); err != nil {
+		return err
+	}
+	defer func() {
+		if err := run.rt.RemoveContainer(spec.Name); err != nil {
+			warnLog.Printf("Unable to remove container %s, error was: %s", spec.Name, err)
+		}
+	}()
+	return run.rt.CopyFrom(spec.Name, p, dir)
+}
+
+// Read a file a step wrote e.g. arachni's converted report, copying it out
+// of the data volume into a temporary directory first
+func readReport(name string, r *stepResult, run *runInfo) ([]byte, error) {
+	tmp, err := ioutil.TempDir("", "gasp-report")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := copyReport(name, tmp, r, run); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filepath.Join(tmp, path.Base(reportPath(name))))
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Size and sha256 of a local file
+func fileSum(file string) (int64, string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Every report collected in this run, in step order
+func (run *runInfo) collectedReports() []ReportEntry {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	entries := make([]ReportEntry, 0)
+	for _, r := range run.results {
+		if r.collected != nil {
+			entries = append(entries, *r.collected)
+		}
+	}
+	return entries
+}
+
+// Write index.json listing the reports collected in this run
+func (run *runInfo) writeIndex() error {
+	if !run.collecting() {
+		return nil
+	}
+	if err := os.MkdirAll(run.reportsDir(), 0755); err != nil {
+		return err
+	}
+
+	idx, err := json.MarshalIndent(run.collectedReports(), "", "  ")
+	if err != nil {
+		return err
+	}
+	f := filepath.Join(run.reportsDir(), reportIndex)
+	if err := ioutil.WriteFile(f, append(idx, '\n'), 0644); err != nil {
+		return err
+	}
+	infoLog.Printf("Wrote the index of collected reports to %s", f)
+	fmt.Printf("Reports collected to %s\n", run.reportsDir())
+
+	return nil
+}
@@ -0,0 +1,59 @@
+// gdocker
+package gdocker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Run the runevery steps after a pipeline step has finished.  Each runevery
+// tool gets the pipeline step's details as environment variables:
+//
+//	GASP_STEP_TOOL         the pipeline tool which just finished e.g. zap
+//	GASP_STEP_TOOL_PROFILE the tool-profile it ran with
+//	GASP_STEP_REPORT       path of its resolved reportname, empty if it has none
+//	GASP_STEP_EXIT_CODE    its exit code, -1 if it timed out or couldn't run
+//	GASP_STEP_STATUS       passed, failed or tolerated
+func runEvery(ctx context.Context, parent *stepResult, run *runInfo) []*stepResult {
+	env := stepEnv(parent)
+
+	results := make([]*stepResult, 0, len(run.runevery))
+	for i := 0; i < len(run.runevery); i++ {
+		s := run.runevery[i]
+		// Pipeline tools run concurrently so name each runevery container after its pipeline tool
+		s.name = s.Tool + "_" + parent.tool.Tool + "_" + run.runId
+		s.env = append(append([]string{}, s.env...), env...)
+
+		r := &stepResult{stage: "runevery", tool: s, parent: parent}
+		fmt.Fprintf(&parent.log.console, "Running runevery tool %v after %v\n", s.Tool, parent.tool.Tool)
+		infoLog.Printf("Running runevery tool %v after %v with %v", s.Tool, parent.tool.Tool, env)
+
+		runStep(ctx, r, run)
+		// Keep the runevery output with the pipeline step it ran after
+		parent.log.console.Write(r.log.console.Bytes())
+		parent.log.detailed.Write(r.log.detailed.Bytes())
+		results = append(results, r)
+
+		if r.status == statusFailed {
+			run.setFailed()
+			break
+		}
+		if r.status == statusCancelled {
+			break
+		}
+	}
+
+	return results
+}
+
+// Environment variables with the details of a pipeline step for runevery tools
+func stepEnv(parent *stepResult) []string {
+	return []string{
+		"GASP_STEP_TOOL=" + parent.tool.Tool,
+		"GASP_STEP_TOOL_PROFILE=" + parent.tool.ToolProfile,
+		"GASP_STEP_REPORT=" + parent.report,
+		"GASP_STEP_EXIT_CODE=" + strconv.Itoa(parent.exitCode),
+		"GASP_STEP_STATUS=" + parent.status,
+	}
+}
@@ -0,0 +1,57 @@
+// gdocker
+package gdocker
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRunEvery(t *testing.T) {
+	r, f, done := newTestRunner(t)
+	defer done()
+	r.Args.Profile = "runevery"
+	id := planTestRun(t, r)
+	f.ExitCodes["lint_"+id] = 1
+
+	res, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	// prep runs after each pipeline tool in a container named after it, with
+	// the tool's details in its environment
+	want := map[string][]string{
+		"prep_scan_" + id: {"GASP_STEP_TOOL=scan", "GASP_STEP_TOOL_PROFILE=all", "GASP_STEP_REPORT=/opt/appsecpipeline/reports/scan.json",
+			"GASP_STEP_EXIT_CODE=0", "GASP_STEP_STATUS=passed"},
+		"prep_lint_" + id: {"GASP_STEP_TOOL=lint", "GASP_STEP_TOOL_PROFILE=all", "GASP_STEP_REPORT=",
+			"GASP_STEP_EXIT_CODE=1", "GASP_STEP_STATUS=failed"},
+	}
+	env := make(map[string][]string)
+	for _, s := range f.Runs {
+		env[s.Name] = s.Env
+	}
+	for name, vars := range want {
+		got, ok := env[name]
+		if !ok {
+			t.Errorf("%s wasn't run, ran %v", name, runNames(f))
+			continue
+		}
+		for _, v := range vars {
+			if !contains(got, v) {
+				t.Errorf("%s env = %v, want %s", name, got, v)
+			}
+		}
+	}
+
+	// and each is reported after its pipeline step
+	steps := make([]string, 0)
+	for _, s := range res.Steps {
+		if s.Stage == "pipeline" || s.Stage == "runevery" {
+			steps = append(steps, s.Stage+"/"+s.Tool)
+		}
+	}
+	if got := strings.Join(steps, " "); got != "pipeline/scan runevery/prep pipeline/lint runevery/prep" {
+		t.Errorf("steps = %s, want each pipeline step followed by runevery prep", got)
+	}
+}
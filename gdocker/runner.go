@@ -0,0 +1,431 @@
+// gdocker
+package gdocker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	g "github.com/appsecpipeline/gasp"
+	"github.com/appsecpipeline/gasp-docker/findings"
+)
+
+// Runner runs a named pipeline and reports back how it went instead of
+// exiting, so gasp-docker can be driven from other Go programs.  Use
+// NewRunner, optionally Plan to check the run, then Run.
+type Runner struct {
+	Args    g.EventArgs
+	Opts    RunOpts
+	ConfDir string  // directory with master.yaml & secpipeline-config.yaml, defaults to ./spec
+	LogDir  string  // directory for gasp-docker's logs, defaults to ./logs
+	Runtime Runtime // container runtime to use, if nil the one named in Opts.Runtime is used
+
+	run  *runInfo
+	sec  g.S
+	plan *Plan
+}
+
+// Plan is what a Runner will do for a run once its configs have been checked
+type Plan struct {
+	RunId     string
+	Pipeline  string // the named pipeline from master.yaml
+	AppName   string
+	Runtime   string   // name of the container runtime
+	Overrides []string // what [app-name]-pipeline.yaml, [app-name]-tool.yaml, --app-profile and --tool-profile changed
+	Steps     []PlanStep
+}
+
+// PlanStep is a single tool run in a Plan
+type PlanStep struct {
+	Stage       string
+	Tool        string
+	ToolProfile string
+	Image       string
+	OnFailure   string
+	Timeout     time.Duration // max-tool-run for the step, zero is no limit
+	Command     string        // command run for the tool, including any pre and post commands
+}
+
+// RunResult is the outcome of a run
+type RunResult struct {
+	RunId     string
+	Pipeline  string
+	ExitCode  int      // one of the Exit* constants
+	Cancelled bool     // the run's context was done before the run completed
+	Removed   []string // containers and volumes removed by the cleanup stage
+	Steps     []StepResult
+	Reports   []ReportEntry // reports collected to --reports, as listed in its index.json
+	Gate      GateResult    // the run's findings against the quality gates in master.yaml
+	Dojo      DojoResult    // what was imported into DefectDojo with --dojo
+}
+
+// DojoResult is what a run imported into DefectDojo
+type DojoResult struct {
+	Product    int
+	Engagement int
+	Imported   []string // tool (scan type) of each report imported
+	Errors     []string // imports and API calls which failed
+}
+
+// StepResult is the outcome of a single step of a run
+type StepResult struct {
+	Stage       string
+	Tool        string
+	ToolProfile string
+	Status      string // passed, failed, tolerated, skipped or cancelled
+	Attempts    int
+	ExitCode    int                // exit code of the last attempt, -1 if the container didn't exit on its own
+	Report      string             // resolved reportname of the tool, if it has one
+	Findings    []findings.Finding // findings read from the tool's report, nil if there weren't any to read
+	Err         error
+}
+
+// NewRunner returns a Runner for the provided args and options using the
+// default config and log directories
+func NewRunner(args g.EventArgs, opts RunOpts) *Runner {
+	return &Runner{
+		Args:    args,
+		Opts:    opts,
+		ConfDir: "./spec",
+		LogDir:  logDir,
+	}
+}
+
+// Plan reads the configs and checks the run against them, returning what
+// will be run.  No images are pulled and no containers are launched.
+func (r *Runner) Plan() (*Plan, error) {
+	if r.plan != nil {
+		return r.plan, nil
+	}
+
+	// Start gasp-docker logging
+	if err := setupLogging(r.LogDir); err != nil {
+		return nil, err
+	}
+	infoLog.Println("Logging setup for gasp-docker")
+
+	// Check Dependencies - a runtime provided by the caller needs no binaries
+	d := g.Deps{
+		Files:         []string{"master.yaml", "secpipeline-config.yaml"},
+		FilePath:      r.ConfDir,
+		ExternalFiles: []string{},
+	}
+	if r.Runtime == nil {
+		d.Bins = runtimeBins(r.Opts.Runtime)
+	}
+	if err := verifyPrereqs(d); err != nil {
+		errorLog.Printf("Missing dependencies for gasp-docker: %s", err)
+		return nil, err
+	}
+	infoLog.Println("All dependencies needed for gasp-docker are available")
+
+	// Read the configs to set things up
+	mstr := g.M{}
+	mc, err := readMaster(r.ConfDir, "master.yaml", &mstr)
+	if err != nil {
+		errorLog.Printf("Unable to read master.yaml, error was: %s", err)
+		return nil, fmt.Errorf("unable to read master.yaml: %v", err)
+	}
+	if err := readSecPipe(r.ConfDir, "secpipeline-config.yaml", &r.sec); err != nil {
+		errorLog.Printf("Unable to read secpipeline-config.yaml, error was: %s", err)
+		return nil, fmt.Errorf("unable to read secpipeline-config.yaml: %v", err)
+	}
+
+	// Merge any per-app named pipelines and tools over master.yaml and secpipeline-config.yaml
+	appDir := r.Opts.AppConfDir
+	if appDir == "" {
+		appDir = r.ConfDir
+	}
+	app, err := readAppConf(appDir, r.Args.AppName)
+	if err != nil {
+		errorLog.Printf("Unable to read the app's config files, error was: %s", err)
+		return nil, err
+	}
+	overrides, err := app.apply(&mstr, &mc, &r.sec)
+	for _, o := range overrides {
+		infoLog.Printf("App override: %s", o)
+	}
+	if err != nil {
+		errorLog.Printf("Unable to merge the app's config files, error was: %s", err)
+		return nil, err
+	}
+
+	// Setup the container runtime
+	rt := r.Runtime
+	if rt == nil {
+		rt, err = NewRuntime(r.Opts.Runtime)
+		if err != nil {
+			errorLog.Printf("Unable to setup the container runtime, error was: %s", err)
+			return nil, err
+		}
+	}
+	infoLog.Printf("Using the %s container runtime", rt.Name())
+
+	// handleEvent
+	eArgs := g.EventArgs{}
+	le := LocalEvent{}
+	le.ReadArgs(&r.Args, &eArgs)
+
+	// Verify the event's data against what's needed for this run
+	// And set runInfo with this runs data if everything checks out
+	// The run ID is set first since {runid} can be used in tool commands
+	run := &runInfo{rt: rt, keepVolume: r.Opts.KeepVolume, exportVolume: r.Opts.ExportVolume,
+		paramsFile: r.Opts.ParamsFile, secretsFile: r.Opts.SecretsFile, sarifFile: r.Opts.SARIF,
+		junitFile: r.Opts.JUnit, junitAll: r.Opts.JUnitAll, dojoNative: r.Opts.Dojo}
+	run.runId = le.GetId()
+	run.overrides = overrides
+	r.run = run
+	if err := verifyRun(&eArgs, &mstr, &mc, &r.sec, run); err != nil {
+		errorLog.Printf("Unable to verify the run, error was: %s", err)
+		return nil, err
+	}
+
+	// Put together what each step will run, reporting every step's problems at once
+	p := &Plan{RunId: run.runId, Pipeline: run.name, AppName: r.Args.AppName, Runtime: rt.Name(), Overrides: run.overrides}
+	problems := make([]string, 0)
+	for _, st := range run.stages() {
+		for i := 0; i < len(st.steps); i++ {
+			s := st.steps[i]
+			if st.name == "runevery" {
+				// runevery steps get details of the pipeline step they run after
+				s.env = append(append([]string{}, s.env...), stepEnv(&stepResult{})...)
+			}
+			if run.nativeStep(s) {
+				p.Steps = append(p.Steps, PlanStep{Stage: st.name, Tool: s.Tool, ToolProfile: s.ToolProfile, OnFailure: s.OnFailure,
+					Command: "none, reports are imported into DefectDojo in-process by --dojo"})
+				continue
+			}
+			cmd, err := genToolCmd(s, run)
+			if err != nil {
+				errorLog.Printf("Unable to build the command for %s in the %s stage, error was: %s", s.Tool, st.name, err)
+				problems = append(problems, fmt.Sprintf("%s stage: %v", st.name, err))
+				continue
+			}
+			// Catch quoting mistakes in secpipeline-config.yaml before anything is run
+			if _, err := cmd.specs(ContainerSpec{Name: s.containerName(run)}); err != nil {
+				errorLog.Printf("Unable to parse the command for %s in the %s stage, error was: %s", s.Tool, st.name, err)
+				problems = append(problems, fmt.Sprintf("%s stage: %s (%s): %v", st.name, s.Tool, s.ToolProfile, err))
+				continue
+			}
+			p.Steps = append(p.Steps, PlanStep{
+				Stage:       st.name,
+				Tool:        s.Tool,
+				ToolProfile: s.ToolProfile,
+				Image:       run.toolProfiles[s.Tool].Docker,
+				OnFailure:   s.OnFailure,
+				Timeout:     s.timeout(run.global),
+				Command:     run.mask(cmd.String()),
+			})
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("unable to build the commands for this run:\n  %s", strings.Join(problems, "\n  "))
+	}
+	r.plan = p
+
+	return p, nil
+}
+
+// ShowParams writes the parameters each tool in the run will get, after
+// merging every source, with secrets masked.  Any problem with the run e.g.
+// a missing parameter is returned after the parameters are written.
+func (r *Runner) ShowParams(w io.Writer) error {
+	_, err := r.Plan()
+	if r.run == nil || r.run.sentParams == nil {
+		return err
+	}
+	r.run.writeParams(w)
+	return err
+}
+
+// Run runs the planned named pipeline, calling Plan first if needed.  An
+// error is only returned if the run couldn't be started or carried out,
+// failed steps are reported in the RunResult and its ExitCode.
+// If ctx is done part way through, any running containers are stopped, no
+// further steps are started, the Cleanup stage is run and the result's
+// ExitCode is ExitCancelled.
+func (r *Runner) Run(ctx context.Context) (*RunResult, error) {
+	if _, err := r.Plan(); err != nil {
+		return nil, err
+	}
+	run := r.run
+
+	// Sync images so all needed tool images are in image repo
+	ldock := LocalDockers{rt: run.rt}
+	if err := ldock.SyncImages(&r.sec); err != nil {
+		return nil, err
+	}
+	//TODO: Look through yaml files to make sure they are consistent on image names & versions
+
+	// Initialize detailed logging
+	fullPath := path.Join(r.LogDir, (run.runId + "_detailed.log"))
+	dL, err := os.Create(fullPath)
+	if err != nil {
+		errorLog.Printf("Failed to open detailed log file %s, error was: %s", fullPath, err)
+		return nil, fmt.Errorf("unable to open detailed log %s: %v", fullPath, err)
+	}
+	run.detailed = dL
+	defer dL.Close()
+
+	// Show what the app's files and flags changed for this run
+	if len(run.overrides) > 0 {
+		fmt.Println("Overrides for this run:")
+		for _, o := range run.overrides {
+			fmt.Printf("  %s\n", o)
+			fmt.Fprintf(run.detailed, "Override: %s\n", o)
+		}
+	}
+
+	// Find or create the DefectDojo product and engagement before anything is run
+	if run.dojo != nil {
+		if err := run.dojo.setup(ctx, run); err != nil {
+			errorLog.Printf("Unable to set up DefectDojo, error was: %s", err)
+			return nil, err
+		}
+	}
+
+	le := LocalEvent{}
+
+	// Run startup stage
+	if err := le.Startup(ctx, run); err != nil && ctx.Err() == nil {
+		// Don't leave a half setup data volume behind
+		le.Cleanup(run)
+		return nil, err
+	}
+
+	// Run pipeline stage
+	if ctx.Err() == nil {
+		le.Pipeline(ctx, run)
+	}
+
+	// Run final stage
+	if ctx.Err() == nil {
+		le.Final(ctx, run)
+	}
+
+	// Everything's been imported so close the DefectDojo engagement
+	if run.dojo != nil && ctx.Err() == nil {
+		run.dojo.close(ctx, run)
+	}
+
+	// TODO: Add more meta to the detailed log - maybe push everything into the main log
+	// TODO: Set a version number for that command line option
+
+	if ctx.Err() != nil {
+		run.cancelled = true
+		warnLog.Printf("Run %s was cancelled: %s", run.runId, ctx.Err())
+		fmt.Println("\nRun cancelled, cleaning up")
+	}
+
+	// List the reports collected to --reports before the data volume goes
+	if err := run.writeIndex(); err != nil {
+		warnLog.Printf("Unable to write the index of collected reports, error was: %s", err)
+		fmt.Printf("Unable to write %s to %s: %s\n", reportIndex, run.reportsDir(), err)
+	}
+
+	// Write out the findings read from the reports as SARIF
+	if err := run.writeSARIFFile(); err != nil {
+		warnLog.Printf("Unable to write the SARIF log, error was: %s", err)
+		fmt.Printf("Unable to write the SARIF log to %s: %s\n", run.sarifFile, err)
+	}
+
+	// Write out how each step went as JUnit XML
+	if err := run.writeJUnitFile(); err != nil {
+		warnLog.Printf("Unable to write the JUnit XML, error was: %s", err)
+		fmt.Printf("Unable to write the JUnit XML to %s: %s\n", run.junitFile, err)
+	}
+
+	// Run cleanup stage, even for a cancelled run
+	le.Cleanup(run)
+
+	// Report how each step went
+	run.summary()
+
+	return run.result(), nil
+}
+
+// WriteSARIF writes the findings read from the tools' reports in the last
+// Run as a SARIF 2.1.0 log with a run per tool
+func (r *Runner) WriteSARIF(w io.Writer) error {
+	if r.run == nil || r.run.results == nil {
+		return fmt.Errorf("there are no findings to write until the pipeline has been run")
+	}
+	return r.run.writeSARIF(w)
+}
+
+// Structured version of a run's step results
+func (run *runInfo) result() *RunResult {
+	res := &RunResult{RunId: run.runId, Pipeline: run.name, ExitCode: run.exitCode(), Cancelled: run.cancelled, Removed: run.removed,
+		Reports: run.collectedReports(), Gate: run.gate()}
+	if d := run.dojo; d != nil {
+		res.Dojo = DojoResult{Product: d.product, Engagement: d.engagement, Imported: d.imported, Errors: d.errs}
+	}
+	for _, sr := range run.results {
+		res.Steps = append(res.Steps, StepResult{
+			Stage:       sr.stage,
+			Tool:        sr.tool.Tool,
+			ToolProfile: sr.tool.ToolProfile,
+			Status:      sr.status,
+			Attempts:    sr.attempts,
+			ExitCode:    sr.exitCode,
+			Report:      sr.report,
+			Findings:    sr.found,
+			Err:         sr.err,
+		})
+	}
+	return res
+}
+
+// Send gasp-docker's logs to a timestamped log file in dir.  Unlike gasp's
+// SetupLogging, a missing log directory is returned as an error.
+func setupLogging(dir string) error {
+	fullPath := path.Join(dir, "gasp-docker_"+strconv.FormatInt(time.Now().UnixNano(), 10)+".log")
+	lf, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("unable to open log file %s, please create any directories needed: %v", fullPath, err)
+	}
+
+	l := g.InitLogs(lf, lf, lf, lf)
+	traceLog = l["trace"]
+	infoLog = l["info"]
+	warnLog = l["warn"]
+	errorLog = l["error"]
+
+	return nil
+}
+
+// Check the needed binaries and files are available, returning every one
+// that's missing.  Unlike gasp's VerifyPrereqs, it doesn't exit.
+func verifyPrereqs(d g.Deps) error {
+	missing := make([]string, 0)
+
+	// Check for required binaries
+	for _, b := range d.Bins {
+		if _, err := exec.LookPath(b); err != nil {
+			missing = append(missing, "the "+b+" command must be installed and in your path")
+		}
+	}
+
+	// Check for required files
+	for _, f := range d.Files {
+		if _, err := os.Stat(path.Join(d.FilePath, f)); err != nil {
+			missing = append(missing, "the "+f+" file must exist in "+d.FilePath)
+		}
+	}
+	for _, f := range d.ExternalFiles {
+		if _, err := os.Stat(f); err != nil {
+			missing = append(missing, "the "+f+" file must exist")
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing dependencies: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
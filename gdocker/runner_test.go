@@ -0,0 +1,425 @@
+// gdocker
+package gdocker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	g "github.com/appsecpipeline/gasp"
+)
+
+// A Runner for the stages named pipeline in testdata using a FakeRuntime,
+// its logs go to a temporary directory removed by the returned func
+func newTestRunner(t *testing.T) (*Runner, *FakeRuntime, func()) {
+	logs, err := ioutil.TempDir("", "gasp-docker-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := g.EventArgs{
+		Profile:     "stages",
+		AppName:     "testapp",
+		Vol:         "none",
+		Src:         "none",
+		Rpt:         "none",
+		AppProfile:  "none",
+		AppToolProf: "none",
+	}
+	r := NewRunner(args, RunOpts{})
+	r.ConfDir = "testdata"
+	r.LogDir = logs
+	f := NewFakeRuntime()
+	r.Runtime = f
+
+	return r, f, func() { os.RemoveAll(logs) }
+}
+
+// Plan the run so its ID is known before the FakeRuntime is set up
+func planTestRun(t *testing.T, r *Runner) string {
+	p, err := r.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	return p.RunId
+}
+
+// The names of the containers a FakeRuntime ran, in order
+func runNames(f *FakeRuntime) []string {
+	names := make([]string, 0, len(f.Runs))
+	for _, s := range f.Runs {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunStages(t *testing.T) {
+	r, f, done := newTestRunner(t)
+	defer done()
+	id := planTestRun(t, r)
+
+	res, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.ExitCode != ExitSuccess {
+		t.Errorf("ExitCode = %d, want %d", res.ExitCode, ExitSuccess)
+	}
+
+	want := []struct{ stage, tool string }{
+		{"startup", "prep"},
+		{"pipeline", "scan"},
+		{"pipeline", "lint"},
+		{"final", "notify"},
+	}
+	if len(res.Steps) != len(want) {
+		t.Fatalf("got %d steps, want %d: %+v", len(res.Steps), len(want), res.Steps)
+	}
+	for i, w := range want {
+		s := res.Steps[i]
+		if s.Stage != w.stage || s.Tool != w.tool || s.Status != statusPassed {
+			t.Errorf("step %d = %s %s %s, want %s %s passed", i, s.Stage, s.Tool, s.Status, w.stage, w.tool)
+		}
+	}
+
+	// The data volume's permissions are set before any tool runs
+	names := runNames(f)
+	if len(names) != 5 || names[0] != "set-perms_"+id || names[1] != "prep_"+id || names[4] != "notify_"+id {
+		t.Errorf("containers run = %v, want set-perms, prep, scan and lint in either order then notify", names)
+	}
+	if f.Runs[0].Image != helperImage {
+		t.Errorf("set-perms image = %s, want %s", f.Runs[0].Image, helperImage)
+	}
+
+	// Every tool gets the data volume, its command, the full path of its report
+	// and the --location default for LOC
+	vol := "data_" + id
+	for _, s := range f.Runs {
+		if len(s.Mounts) == 0 || s.Mounts[0].Source != vol || s.Mounts[0].Target != "/opt/appsecpipeline/" {
+			t.Errorf("%s mounts = %+v, want %s at /opt/appsecpipeline/", s.Name, s.Mounts, vol)
+		}
+		if !s.Remove {
+			t.Errorf("%s wasn't run with Remove set", s.Name)
+		}
+	}
+	for _, s := range f.Runs {
+		if s.Name == "scan_"+id {
+			if got := strings.Join(s.Cmd, " "); got != "scan --out /opt/appsecpipeline/reports/scan.json "+defaultLoc {
+				t.Errorf("scan command = %s, want scan --out /opt/appsecpipeline/reports/scan.json %s", got, defaultLoc)
+			}
+		}
+		// Tools keep their image's working directory
+		if s.WorkDir != "" {
+			t.Errorf("%s WorkDir = %s, want the image's", s.Name, s.WorkDir)
+		}
+	}
+
+	// The images were pulled and the data volume is gone after the cleanup stage
+	for _, img := range []string{"gasp-test/prep:1.0", "gasp-test/scan:1.0", "gasp-test/lint:1.0", "gasp-test/notify:1.0"} {
+		if !contains(f.Pulled, img) {
+			t.Errorf("%s wasn't pulled, pulled %v", img, f.Pulled)
+		}
+	}
+	if len(f.Volumes) != 0 {
+		t.Errorf("volumes left after the run: %v", f.Volumes)
+	}
+	if !contains(res.Removed, "volume "+vol) {
+		t.Errorf("Removed = %v, want volume %s", res.Removed, vol)
+	}
+}
+
+func TestRunFailedStep(t *testing.T) {
+	r, f, done := newTestRunner(t)
+	defer done()
+	id := planTestRun(t, r)
+	f.ExitCodes["scan_"+id] = 2
+
+	res, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.ExitCode != ExitFailed {
+		t.Errorf("ExitCode = %d, want %d", res.ExitCode, ExitFailed)
+	}
+
+	status := make(map[string]string)
+	for _, s := range res.Steps {
+		status[s.Tool] = s.Status
+		if s.Tool == "scan" && s.ExitCode != 2 {
+			t.Errorf("scan ExitCode = %d, want 2", s.ExitCode)
+		}
+	}
+	if status["scan"] != statusFailed {
+		t.Errorf("scan status = %s, want %s", status["scan"], statusFailed)
+	}
+	// A failed step stops the run so the final stage is skipped
+	if status["notify"] != statusSkipped {
+		t.Errorf("notify status = %s, want %s", status["notify"], statusSkipped)
+	}
+	if contains(runNames(f), "notify_"+id) {
+		t.Errorf("notify was run after scan failed")
+	}
+	if len(f.Volumes) != 0 {
+		t.Errorf("volumes left after the run: %v", f.Volumes)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	r, f, done := newTestRunner(t)
+	defer done()
+	id := planTestRun(t, r)
+	f.Hang["scan_"+id] = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	res, err := r.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.ExitCode != ExitCancelled || !res.Cancelled {
+		t.Errorf("ExitCode = %d and Cancelled = %v, want %d and true", res.ExitCode, res.Cancelled, ExitCancelled)
+	}
+
+	// The hung tool is stopped, nothing else is started and cleanup still runs
+	if !contains(f.Killed, "scan_"+id) {
+		t.Errorf("Killed = %v, want scan_%s", f.Killed, id)
+	}
+	if contains(runNames(f), "notify_"+id) {
+		t.Errorf("notify was run after the run was cancelled")
+	}
+	for _, s := range res.Steps {
+		if s.Tool == "scan" && s.Status != statusCancelled {
+			t.Errorf("scan status = %s, want %s", s.Status, statusCancelled)
+		}
+	}
+	if len(f.Volumes) != 0 {
+		t.Errorf("volumes left after the run: %v", f.Volumes)
+	}
+}
+
+func TestSyncImagesPullsHelper(t *testing.T) {
+	f := NewFakeRuntime("gasp-test/scan:1.0")
+	delete(f.Images, helperImage)
+	sec := &g.S{T: map[string]g.SecTool{"scan": {Docker: "gasp-test/scan:1.0"}}}
+
+	ldock := LocalDockers{rt: f}
+	if err := ldock.SyncImages(sec); err != nil {
+		t.Fatalf("SyncImages failed: %v", err)
+	}
+	if len(f.Pulled) != 1 || f.Pulled[0] != helperImage {
+		t.Errorf("Pulled = %v, want just %s", f.Pulled, helperImage)
+	}
+}
+
+func TestRunKeep(t *testing.T) {
+	r, f, done := newTestRunner(t)
+	defer done()
+	r.Args.Keep = true
+	id := planTestRun(t, r)
+
+	res, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// Kept containers go in the cleanup stage but --keep leaves the data volume
+	if len(f.Containers) != 0 {
+		t.Errorf("containers left after the run: %v", f.Containers)
+	}
+	if !f.Volumes["data_"+id] {
+		t.Errorf("data volume was removed with --keep, removed %v", res.Removed)
+	}
+}
+
+func TestRuntimeBin(t *testing.T) {
+	tests := []struct {
+		rt   Runtime
+		want string
+	}{
+		{NewCLIRuntime(), "docker"},
+		{NewPodmanRuntime(), "podman"},
+		{&APIRuntime{}, "docker"},
+	}
+	for _, tt := range tests {
+		run := &runInfo{rt: tt.rt}
+		if got := run.runtimeBin(); got != tt.want {
+			t.Errorf("runtimeBin for %T = %s, want %s", tt.rt, got, tt.want)
+		}
+	}
+}
+
+// The profiles bundled in spec plan with just their tools' required parameters,
+// LOC comes from --location
+func TestBundledProfiles(t *testing.T) {
+	dojo := "DOJO_HOST=http://dojo:8000 DOJO_API_KEY=key DOJO_PRODUCT_ID=1 DOJO_ENGAGEMENT_ID=2 DOJO_DIR=/opt/appsecpipeline/reports"
+	tests := []struct{ profile, params string }{
+		{"sourcecode", dojo + " GIT_URL=https://example.com/app.git GIT_TAGS=main" +
+			" CHECKMARX_URL=https://cx CHECKMARX_USERNAME=cx CHECKMARX_PASSWORD=cx CHECKMARX_PROJECT=7"},
+		{"standard", dojo + " URL=https://example.com"},
+		{"production", dojo + " URL=https://example.com TARGET=example.com"},
+	}
+	for _, tt := range tests {
+		r, _, done := newTestRunner(t)
+		r.ConfDir = "../spec"
+		r.Args.Profile = tt.profile
+		r.Args.ParamsRaw = tt.params
+		p, err := r.Plan()
+		if err != nil {
+			t.Errorf("%s profile: %v", tt.profile, err)
+			done()
+			continue
+		}
+		// Utility and code-analyzer tools get LOC from --location too
+		for _, s := range p.Steps {
+			if (s.Tool == "git" || s.Tool == "cloc") && !strings.Contains(s.Command, defaultLoc) {
+				t.Errorf("%s profile: %s command = %s, want LOC %s", tt.profile, s.Tool, s.Command, defaultLoc)
+			}
+		}
+		done()
+	}
+}
+
+func TestPlanBadConfig(t *testing.T) {
+	for _, file := range []string{"master.yaml", "secpipeline-config.yaml"} {
+		r, _, done := newTestRunner(t)
+		conf, err := ioutil.TempDir("", "gasp-docker-conf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range []string{"master.yaml", "secpipeline-config.yaml"} {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c == file {
+				data = append(data, "\n\tnot: [yaml\n"...)
+			}
+			if err := ioutil.WriteFile(filepath.Join(conf, c), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		r.ConfDir = conf
+		if _, err := r.Plan(); err == nil || !strings.Contains(err.Error(), "unable to read "+file) {
+			t.Errorf("Plan with a broken %s returned %v", file, err)
+		}
+		os.RemoveAll(conf)
+		done()
+	}
+}
+
+func TestSecretsOffCommandLine(t *testing.T) {
+	r, f, done := newTestRunner(t)
+	defer done()
+	r.Args.ParamsRaw = "notify.NOTIFY_KEY=n0tify lint.LINT_TOKEN=l1nt"
+	id := planTestRun(t, r)
+
+	// notify's entrypoint expands secrets itself (secrets: env), lint's doesn't
+	tc, err := genToolCmd(step{Tools: g.Tools{Tool: "notify", ToolProfile: "all"}}, r.run)
+	if err != nil || len(tc.exposed) != 0 {
+		t.Errorf("notify command exposed %v, error %v", tc.exposed, err)
+	}
+	tc, err = genToolCmd(step{Tools: g.Tools{Tool: "lint", ToolProfile: "all"}}, r.run)
+	if err != nil || strings.Join(tc.exposed, " ") != "LINT_TOKEN" {
+		t.Errorf("lint command exposed %v, want LINT_TOKEN, error %v", tc.exposed, err)
+	}
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	for _, s := range f.Runs {
+		switch s.Name {
+		case "notify_" + id:
+			if got := strings.Join(s.Cmd, " "); got != "notify --app testapp --key=${NOTIFY_KEY}" {
+				t.Errorf("notify command = %s, want the secret left as ${NOTIFY_KEY}", got)
+			}
+			if strings.Join(s.Secrets, " ") != "NOTIFY_KEY=n0tify" {
+				t.Errorf("notify secrets = %v, want NOTIFY_KEY=n0tify", s.Secrets)
+			}
+		case "lint_" + id:
+			if got := strings.Join(s.Cmd, " "); got != "lint --strict --token=l1nt" {
+				t.Errorf("lint command = %s, want lint --strict --token=l1nt", got)
+			}
+		}
+	}
+}
+
+func TestCollectReports(t *testing.T) {
+	r, f, done := newTestRunner(t)
+	defer done()
+	rpt, err := ioutil.TempDir("", "gasp-docker-reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rpt)
+	r.Args.Profile = "reports"
+	r.Args.Rpt = rpt
+	id := planTestRun(t, r)
+	f.Files["/opt/appsecpipeline/reports/scan.json"] = `{"results": []}`
+
+	res, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	// scan runs in two stages so each of its reports gets its own directory
+	want := []string{"startup/1_scan/scan.json", "pipeline/2_scan/scan.json"}
+	if len(res.Reports) != len(want) {
+		t.Fatalf("got %d reports, want %d: %+v", len(res.Reports), len(want), res.Reports)
+	}
+	for i, w := range want {
+		e := res.Reports[i]
+		if e.Path != w || e.Error != "" || e.Size != int64(len(f.Files["/opt/appsecpipeline/reports/scan.json"])) {
+			t.Errorf("report %d = %+v, want %s", i, e, w)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(rpt, id, filepath.FromSlash(w)))
+		if err != nil || string(data) != f.Files["/opt/appsecpipeline/reports/scan.json"] {
+			t.Errorf("collected %s = %q, error %v", w, data, err)
+		}
+	}
+
+	// Reports are copied out of a helper container which is removed after
+	if !contains(f.Copied, "report_scan_"+id+":/opt/appsecpipeline/reports/scan.json") {
+		t.Errorf("Copied = %v, want report_scan_%s", f.Copied, id)
+	}
+	if len(f.Containers) != 0 {
+		t.Errorf("containers left after the run: %v", f.Containers)
+	}
+}
+
+// A FakeRuntime which handles volume ownership itself like rootless podman
+type ownerRuntime struct {
+	*FakeRuntime
+}
+
+func (ownerRuntime) OwnsVolumes() bool {
+	return true
+}
+
+func TestVolumePermsOwner(t *testing.T) {
+	r, f, done := newTestRunner(t)
+	defer done()
+	r.Runtime = ownerRuntime{f}
+	id := planTestRun(t, r)
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// The reports directory is still created, just without the chown as root
+	s := f.Runs[0]
+	if s.Name != "set-perms_"+id || s.User != "" || strings.Join(s.Cmd, " ") != "-c mkdir -p "+reportDir {
+		t.Errorf("first container = %s as %q running %v, want set-perms_%s running mkdir -p %s", s.Name, s.User, s.Cmd, id, reportDir)
+	}
+}
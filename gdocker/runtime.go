@@ -0,0 +1,104 @@
+// gdocker
+package gdocker
+
+import (
+	"context"
+	"fmt"
+)
+
+// Runtime is the container backend used by the stages of a pipeline run.
+// The stage logic only talks to a Runtime so other backends can be swapped
+// in without touching Startup, Pipeline or Final.
+type Runtime interface {
+	// Name of the backend, used for logging
+	Name() string
+	// List the container images available locally
+	ListImages() ([]Image, error)
+	// Pull the named image (name:tag) into the local image store
+	PullImage(name string) error
+	// Create a named volume
+	CreateVolume(name string) error
+	// Remove a named volume
+	RemoveVolume(name string) error
+	// Run a container to completion and return its output and exit code.  If ctx
+	// is done first, stop waiting and return ctx's error - the container may
+	// still be running and need to be killed.
+	RunContainer(ctx context.Context, spec ContainerSpec) (ContainerResult, error)
+	// Remove a stopped container
+	RemoveContainer(name string) error
+	// Copy a file or directory out of a container into the local dst directory
+	CopyFrom(container string, src string, dst string) error
+	// Kill a running container
+	Kill(name string) error
+}
+
+// VolumeOwner is implemented by runtimes which give the container's user
+// ownership of data volumes themselves, removing the need to chown them
+type VolumeOwner interface {
+	OwnsVolumes() bool
+}
+
+// NewRuntime returns the named Runtime - "docker" for the docker cli,
+// "docker-api" for the Docker Engine API found at DOCKER_HOST or the default
+// socket or "podman" for the podman cli
+func NewRuntime(name string) (Runtime, error) {
+	switch name {
+	case "", "docker":
+		return NewCLIRuntime(), nil
+	case "docker-api":
+		return NewAPIRuntime("")
+	case "podman":
+		return NewPodmanRuntime(), nil
+	}
+	return nil, fmt.Errorf("unknown container runtime '%s', expected docker, docker-api or podman", name)
+}
+
+// Binaries that need to be in $PATH for the named Runtime
+func runtimeBins(name string) []string {
+	switch name {
+	case "", "docker":
+		return []string{"docker"}
+	case "podman":
+		return []string{"podman"}
+	}
+	return []string{}
+}
+
+// Image is a container image available to a Runtime
+type Image struct {
+	FullName string // name:tag
+	Name     string
+	Tag      string
+	ID       string
+	Created  string
+	Size     string
+}
+
+// Mount is a volume or local path mounted into a container
+type Mount struct {
+	Source string // volume name or full path on the local filesystem
+	Target string // path inside the container
+}
+
+// ContainerSpec describes a single container launch
+type ContainerSpec struct {
+	Name       string
+	Image      string
+	Cmd        []string
+	Entrypoint string
+	User       string
+	WorkDir    string   // working directory inside the container, the image's if empty
+	Env        []string // NAME=value environment variables for the container
+	Secrets    []string // NAME=value environment variables kept off the client's command-line e.g. API keys
+	Mounts     []Mount
+	HostNet    bool // share the host's network stack aka --net=host
+	Remove     bool // remove the container when it exits aka --rm
+}
+
+// ContainerResult is the outcome of a container run
+type ContainerResult struct {
+	ID       string
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+}
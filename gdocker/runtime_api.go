@@ -0,0 +1,418 @@
+// gdocker
+package gdocker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Default location of the Docker Engine's API socket
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// APIRuntime talks to the Docker Engine HTTP API directly instead of
+// shelling out to the docker command-line client
+type APIRuntime struct {
+	Host   string       // DOCKER_HOST style address e.g. unix:///var/run/docker.sock or tcp://127.0.0.1:2375
+	client *http.Client // client configured to reach Host
+	base   string       // base URL requests are made against
+}
+
+// APIError is an error response returned by the Docker Engine API
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("docker api returned %d: %s", e.StatusCode, e.Message)
+}
+
+// NewAPIRuntime returns a Runtime using the Docker Engine API at host.  If host
+// is empty, DOCKER_HOST is used and then the default unix socket.
+func NewAPIRuntime(host string) (*APIRuntime, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = "unix://" + defaultDockerSocket
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse docker host %s: %v", host, err)
+	}
+
+	a := APIRuntime{Host: host}
+	switch u.Scheme {
+	case "unix":
+		sock := u.Path
+		a.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", sock)
+				},
+			},
+		}
+		// Host portion is ignored when dialing the socket
+		a.base = "http://docker"
+	case "tcp", "http":
+		a.client = &http.Client{}
+		a.base = "http://" + u.Host
+	case "https":
+		a.client = &http.Client{}
+		a.base = "https://" + u.Host
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme %s in %s", u.Scheme, host)
+	}
+
+	return &a, nil
+}
+
+func (a *APIRuntime) Name() string {
+	return "docker api at " + a.Host
+}
+
+// Make a request against the Engine API returning the response if it succeeded
+func (a *APIRuntime) do(ctx context.Context, method string, p string, q url.Values, body interface{}) (*http.Response, error) {
+	var rdr io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rdr = bytes.NewReader(b)
+	}
+
+	u := a.base + p
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequest(method, u, rdr)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg := struct {
+			Message string `json:"message"`
+		}{}
+		raw, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(raw, &msg) != nil || msg.Message == "" {
+			msg.Message = strings.TrimSpace(string(raw))
+		}
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: msg.Message}
+	}
+
+	return resp, nil
+}
+
+// Make a request and decode any JSON response into out
+func (a *APIRuntime) call(ctx context.Context, method string, p string, q url.Values, body interface{}, out interface{}) error {
+	resp, err := a.do(ctx, method, p, q, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (a *APIRuntime) ListImages() ([]Image, error) {
+	var list []struct {
+		ID       string   `json:"Id"`
+		RepoTags []string `json:"RepoTags"`
+		Created  int64    `json:"Created"`
+		Size     int64    `json:"Size"`
+	}
+	if err := a.call(context.Background(), "GET", "/images/json", nil, nil, &list); err != nil {
+		return nil, fmt.Errorf("listing images failed: %v", err)
+	}
+
+	images := make([]Image, 0, len(list))
+	for _, l := range list {
+		// One image can carry several name:tag's, list each of them
+		for _, rt := range l.RepoTags {
+			name, tag := splitImage(rt)
+			images = append(images, Image{
+				FullName: rt,
+				Name:     name,
+				Tag:      tag,
+				ID:       l.ID,
+				Created:  time.Unix(l.Created, 0).Format(time.RFC3339),
+				Size:     fmt.Sprintf("%d", l.Size),
+			})
+		}
+	}
+	return images, nil
+}
+
+func (a *APIRuntime) PullImage(name string) error {
+	img, tag := splitImage(name)
+	q := url.Values{"fromImage": {img}, "tag": {tag}}
+	resp, err := a.do(context.Background(), "POST", "/images/create", q, nil)
+	if err != nil {
+		return fmt.Errorf("pulling image %s failed: %v", name, err)
+	}
+	defer resp.Body.Close()
+
+	// Pull progress is streamed as JSON messages, any failure shows up as an error message
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("pulling image %s failed reading progress: %v", name, err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("pulling image %s failed: %s", name, msg.Error)
+		}
+	}
+	return nil
+}
+
+func (a *APIRuntime) CreateVolume(name string) error {
+	body := map[string]string{"Name": name}
+	if err := a.call(context.Background(), "POST", "/volumes/create", nil, body, nil); err != nil {
+		return fmt.Errorf("creating volume %s failed: %v", name, err)
+	}
+	return nil
+}
+
+func (a *APIRuntime) RemoveVolume(name string) error {
+	if err := a.call(context.Background(), "DELETE", "/volumes/"+url.PathEscape(name), nil, nil, nil); err != nil {
+		return fmt.Errorf("removing volume %s failed: %v", name, err)
+	}
+	return nil
+}
+
+// Body of a container create request
+type apiCreate struct {
+	Image      string
+	Cmd        []string      `json:",omitempty"`
+	Entrypoint []string      `json:",omitempty"`
+	User       string        `json:",omitempty"`
+	WorkingDir string        `json:",omitempty"`
+	Env        []string      `json:",omitempty"`
+	HostConfig apiHostConfig `json:"HostConfig"`
+}
+
+type apiHostConfig struct {
+	Binds       []string `json:",omitempty"`
+	NetworkMode string   `json:",omitempty"`
+}
+
+func (a *APIRuntime) RunContainer(ctx context.Context, spec ContainerSpec) (ContainerResult, error) {
+	res := ContainerResult{}
+
+	body := apiCreate{
+		Image:      spec.Image,
+		Cmd:        spec.Cmd,
+		User:       spec.User,
+		WorkingDir: spec.WorkDir,
+		Env:        append(append([]string{}, spec.Env...), spec.Secrets...),
+	}
+	if spec.Entrypoint != "" {
+		body.Entrypoint = []string{spec.Entrypoint}
+	}
+	for _, m := range spec.Mounts {
+		body.HostConfig.Binds = append(body.HostConfig.Binds, m.Source+":"+m.Target)
+	}
+	if spec.HostNet {
+		body.HostConfig.NetworkMode = "host"
+	}
+
+	// Create the container
+	var created struct {
+		ID       string   `json:"Id"`
+		Warnings []string `json:"Warnings"`
+	}
+	q := url.Values{}
+	if spec.Name != "" {
+		q.Set("name", spec.Name)
+	}
+	err := a.call(ctx, "POST", "/containers/create", q, body, &created)
+	// Unlike docker run, the API doesn't pull a missing image so pull it and try again
+	if ae, ok := err.(*APIError); ok && ae.StatusCode == http.StatusNotFound {
+		infoLog.Printf("Image %s for container %s isn't available, pulling it", spec.Image, spec.Name)
+		if perr := a.PullImage(spec.Image); perr != nil {
+			return res, fmt.Errorf("creating container %s failed: %v", spec.Name, perr)
+		}
+		err = a.call(ctx, "POST", "/containers/create", q, body, &created)
+	}
+	if err != nil {
+		return res, fmt.Errorf("creating container %s failed: %v", spec.Name, err)
+	}
+	res.ID = created.ID
+
+	// Start it and wait for it to exit
+	if err := a.call(ctx, "POST", "/containers/"+created.ID+"/start", nil, nil, nil); err != nil {
+		a.cleanup(spec, created.ID)
+		return res, fmt.Errorf("starting container %s failed: %v", spec.Name, err)
+	}
+	var waited struct {
+		StatusCode int `json:"StatusCode"`
+		Error      *struct {
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}
+	if err := a.call(ctx, "POST", "/containers/"+created.ID+"/wait", nil, nil, &waited); err != nil {
+		// Leave a container that's still running for the caller to kill
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		a.cleanup(spec, created.ID)
+		return res, fmt.Errorf("waiting on container %s failed: %v", spec.Name, err)
+	}
+	if waited.Error != nil && waited.Error.Message != "" {
+		a.cleanup(spec, created.ID)
+		return res, fmt.Errorf("waiting on container %s failed: %s", spec.Name, waited.Error.Message)
+	}
+	res.ExitCode = waited.StatusCode
+
+	// Collect the output before the container is removed
+	res.Stdout, res.Stderr, err = a.logs(created.ID)
+	if err != nil {
+		a.cleanup(spec, created.ID)
+		return res, fmt.Errorf("reading logs of container %s failed: %v", spec.Name, err)
+	}
+
+	return res, a.cleanup(spec, created.ID)
+}
+
+// Remove the container if the spec asked for it aka --rm
+func (a *APIRuntime) cleanup(spec ContainerSpec, id string) error {
+	if !spec.Remove {
+		return nil
+	}
+	return a.RemoveContainer(id)
+}
+
+// Read and demultiplex the stdout and stderr of a non-tty container
+func (a *APIRuntime) logs(id string) ([]byte, []byte, error) {
+	q := url.Values{"stdout": {"1"}, "stderr": {"1"}}
+	resp, err := a.do(context.Background(), "GET", "/containers/"+id+"/logs", q, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	// Each frame is an 8 byte header - stream type, 3 empty bytes, 4 byte big endian size
+	var sOut, sErr bytes.Buffer
+	hdr := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(resp.Body, hdr); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		w := &sOut
+		if hdr[0] == 2 {
+			w = &sErr
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[4:]))
+		if _, err := io.CopyN(w, resp.Body, size); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return sOut.Bytes(), sErr.Bytes(), nil
+}
+
+func (a *APIRuntime) RemoveContainer(name string) error {
+	q := url.Values{"force": {"1"}}
+	if err := a.call(context.Background(), "DELETE", "/containers/"+url.PathEscape(name), q, nil, nil); err != nil {
+		return fmt.Errorf("removing container %s failed: %v", name, err)
+	}
+	return nil
+}
+
+func (a *APIRuntime) CopyFrom(container string, src string, dst string) error {
+	q := url.Values{"path": {src}}
+	resp, err := a.do(context.Background(), "GET", "/containers/"+url.PathEscape(container)+"/archive", q, nil)
+	if err != nil {
+		return fmt.Errorf("copying %s:%s failed: %v", container, src, err)
+	}
+	defer resp.Body.Close()
+
+	if err := untar(resp.Body, dst); err != nil {
+		return fmt.Errorf("copying %s:%s failed: %v", container, src, err)
+	}
+	return nil
+}
+
+func (a *APIRuntime) Kill(name string) error {
+	if err := a.call(context.Background(), "POST", "/containers/"+url.PathEscape(name)+"/kill", nil, nil, nil); err != nil {
+		return fmt.Errorf("killing container %s failed: %v", name, err)
+	}
+	return nil
+}
+
+// Extract a tar stream into the dst directory
+func untar(r io.Reader, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Don't allow archive entries to escape dst
+		target := filepath.Join(dst, filepath.Clean("/"+h.Name))
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(h.Mode)&0777)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// gdocker
+package gdocker
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A stand-in for the Docker Engine API with just enough of it for APIRuntime
+type fakeEngine struct {
+	images     map[string]bool
+	volumes    map[string]bool
+	containers map[string]apiCreate // created containers keyed by ID
+	names      map[string]string    // container IDs keyed by name
+	calls      []string             // METHOD path of every request, in order
+	mu         sync.Mutex
+}
+
+func newFakeEngine(images ...string) *fakeEngine {
+	e := &fakeEngine{
+		images:     make(map[string]bool),
+		volumes:    make(map[string]bool),
+		containers: make(map[string]apiCreate),
+		names:      make(map[string]string),
+	}
+	for _, i := range images {
+		e.images[i] = true
+	}
+	return e
+}
+
+// Write an API error the way the engine does
+func engineError(w http.ResponseWriter, code int, msg string) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"message": msg})
+}
+
+// Write a frame of a non-tty container's multiplexed logs
+func logFrame(w http.ResponseWriter, stream byte, s string) {
+	hdr := make([]byte, 8)
+	hdr[0] = stream
+	binary.BigEndian.PutUint32(hdr[4:], uint32(len(s)))
+	w.Write(hdr)
+	w.Write([]byte(s))
+}
+
+func (e *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls = append(e.calls, r.Method+" "+r.URL.Path)
+
+	p := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/images/create":
+		img := r.URL.Query().Get("fromImage") + ":" + r.URL.Query().Get("tag")
+		e.images[img] = true
+		w.Write([]byte(`{"status":"Pulling from ` + img + `"}` + "\n" + `{"status":"Download complete"}` + "\n"))
+
+	case r.Method == "POST" && r.URL.Path == "/containers/create":
+		var body apiCreate
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			engineError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !e.images[body.Image] {
+			engineError(w, http.StatusNotFound, "No such image: "+body.Image)
+			return
+		}
+		id := "id-" + r.URL.Query().Get("name")
+		e.containers[id] = body
+		e.names[r.URL.Query().Get("name")] = id
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Id":"` + id + `","Warnings":[]}`))
+
+	case r.Method == "POST" && len(p) == 3 && p[0] == "containers" && p[2] == "start":
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "POST" && len(p) == 3 && p[0] == "containers" && p[2] == "wait":
+		w.Write([]byte(`{"StatusCode":3}`))
+
+	case r.Method == "GET" && len(p) == 3 && p[0] == "containers" && p[2] == "logs":
+		logFrame(w, 1, "scanning\n")
+		logFrame(w, 2, "warning: slow\n")
+		logFrame(w, 1, "done\n")
+
+	case r.Method == "POST" && len(p) == 3 && p[0] == "containers" && p[2] == "kill":
+		if _, ok := e.names[p[1]]; !ok {
+			engineError(w, http.StatusNotFound, "No such container: "+p[1])
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "DELETE" && len(p) == 2 && p[0] == "containers":
+		if _, ok := e.containers[p[1]]; !ok {
+			engineError(w, http.StatusNotFound, "No such container: "+p[1])
+			return
+		}
+		delete(e.containers, p[1])
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "POST" && r.URL.Path == "/volumes/create":
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		e.volumes[body["Name"]] = true
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Name":"` + body["Name"] + `"}`))
+
+	case r.Method == "DELETE" && len(p) == 2 && p[0] == "volumes":
+		if !e.volumes[p[1]] {
+			engineError(w, http.StatusNotFound, "get "+p[1]+": no such volume")
+			return
+		}
+		delete(e.volumes, p[1])
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		engineError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+// An APIRuntime talking to a fakeEngine, call the returned func when done
+func newTestAPIRuntime(t *testing.T, e *fakeEngine) (*APIRuntime, func()) {
+	srv := httptest.NewServer(e)
+	a, err := NewAPIRuntime("tcp://" + strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return a, srv.Close
+}
+
+func TestAPIRuntimeRunContainer(t *testing.T) {
+	e := newFakeEngine("gasp-test/scan:1.0")
+	a, done := newTestAPIRuntime(t, e)
+	defer done()
+
+	spec := ContainerSpec{
+		Name:    "scan_1234",
+		Image:   "gasp-test/scan:1.0",
+		Cmd:     []string{"--out", "scan.json"},
+		User:    "appsecpipeline",
+		Env:     []string{"GASP_STEP_TOOL=zap"},
+		Secrets: []string{"API_KEY=s3cret"},
+		Mounts:  []Mount{{Source: "data_1234", Target: "/opt/appsecpipeline/"}},
+		HostNet: true,
+		Remove:  true,
+	}
+	res, err := a.RunContainer(context.Background(), spec)
+	if err != nil {
+		t.Fatalf("RunContainer failed: %v", err)
+	}
+	if res.ID != "id-scan_1234" || res.ExitCode != 3 {
+		t.Errorf("ID = %s and ExitCode = %d, want id-scan_1234 and 3", res.ID, res.ExitCode)
+	}
+	// stdout and stderr are demultiplexed from the logs
+	if string(res.Stdout) != "scanning\ndone\n" || string(res.Stderr) != "warning: slow\n" {
+		t.Errorf("Stdout = %q and Stderr = %q", res.Stdout, res.Stderr)
+	}
+
+	want := []string{
+		"POST /containers/create",
+		"POST /containers/id-scan_1234/start",
+		"POST /containers/id-scan_1234/wait",
+		"GET /containers/id-scan_1234/logs",
+		"DELETE /containers/id-scan_1234",
+	}
+	if strings.Join(e.calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("calls = %v, want %v", e.calls, want)
+	}
+	if len(e.containers) != 0 {
+		t.Errorf("container wasn't removed after running with Remove set")
+	}
+}
+
+func TestAPIRuntimeCreateBody(t *testing.T) {
+	e := newFakeEngine("gasp-test/scan:1.0")
+	a, done := newTestAPIRuntime(t, e)
+	defer done()
+
+	spec := ContainerSpec{
+		Name:       "scan_1234",
+		Image:      "gasp-test/scan:1.0",
+		Entrypoint: "sh",
+		Cmd:        []string{"-c", "scan"},
+		WorkDir:    "/zap/wrk",
+		Env:        []string{"A=1"},
+		Secrets:    []string{"API_KEY=s3cret"},
+		Mounts:     []Mount{{Source: "data_1234", Target: "/opt/appsecpipeline/"}},
+		HostNet:    true,
+	}
+	if _, err := a.RunContainer(context.Background(), spec); err != nil {
+		t.Fatalf("RunContainer failed: %v", err)
+	}
+
+	// Kept since Remove isn't set
+	c, ok := e.containers["id-scan_1234"]
+	if !ok {
+		t.Fatalf("container was removed without Remove set")
+	}
+	if strings.Join(c.Entrypoint, " ") != "sh" || strings.Join(c.Cmd, " ") != "-c scan" || c.WorkingDir != "/zap/wrk" {
+		t.Errorf("Entrypoint = %v, Cmd = %v and WorkingDir = %s", c.Entrypoint, c.Cmd, c.WorkingDir)
+	}
+	// Secrets go in the environment, never the command
+	if strings.Join(c.Env, " ") != "A=1 API_KEY=s3cret" {
+		t.Errorf("Env = %v, want A=1 API_KEY=s3cret", c.Env)
+	}
+	if strings.Join(c.HostConfig.Binds, " ") != "data_1234:/opt/appsecpipeline/" || c.HostConfig.NetworkMode != "host" {
+		t.Errorf("HostConfig = %+v", c.HostConfig)
+	}
+}
+
+func TestAPIRuntimePullsMissingImage(t *testing.T) {
+	e := newFakeEngine()
+	a, done := newTestAPIRuntime(t, e)
+	defer done()
+
+	spec := ContainerSpec{Name: "set-perms_1234", Image: helperImage, Remove: true}
+	if _, err := a.RunContainer(context.Background(), spec); err != nil {
+		t.Fatalf("RunContainer failed: %v", err)
+	}
+	if !e.images[helperImage] {
+		t.Errorf("%s wasn't pulled", helperImage)
+	}
+	if len(e.calls) < 3 || e.calls[0] != "POST /containers/create" || e.calls[1] != "POST /images/create" || e.calls[2] != "POST /containers/create" {
+		t.Errorf("calls = %v, want create, pull then create again", e.calls)
+	}
+}
+
+func TestAPIRuntimeKill(t *testing.T) {
+	e := newFakeEngine("gasp-test/scan:1.0")
+	a, done := newTestAPIRuntime(t, e)
+	defer done()
+
+	if _, err := a.RunContainer(context.Background(), ContainerSpec{Name: "scan_1234", Image: "gasp-test/scan:1.0"}); err != nil {
+		t.Fatalf("RunContainer failed: %v", err)
+	}
+	if err := a.Kill("scan_1234"); err != nil {
+		t.Errorf("Kill failed: %v", err)
+	}
+	if err := a.Kill("nosuch_1234"); err == nil || !strings.Contains(err.Error(), "No such container") {
+		t.Errorf("Kill of a missing container returned %v", err)
+	}
+}
+
+func TestAPIRuntimeVolumes(t *testing.T) {
+	e := newFakeEngine()
+	a, done := newTestAPIRuntime(t, e)
+	defer done()
+
+	if err := a.CreateVolume("data_1234"); err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if !e.volumes["data_1234"] {
+		t.Errorf("volume data_1234 wasn't created")
+	}
+	if err := a.RemoveVolume("data_1234"); err != nil {
+		t.Errorf("RemoveVolume failed: %v", err)
+	}
+	if len(e.volumes) != 0 {
+		t.Errorf("volume data_1234 wasn't removed")
+	}
+
+	if err := a.RemoveVolume("data_1234"); err == nil || !strings.Contains(err.Error(), "no such volume") {
+		t.Errorf("RemoveVolume of a missing volume returned %v", err)
+	}
+}
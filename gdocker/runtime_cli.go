@@ -0,0 +1,216 @@
+// gdocker
+package gdocker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// CLIRuntime runs containers by shelling out to the docker command-line client
+type CLIRuntime struct {
+	Bin string // the client binary to call, defaults to "docker"
+
+	// Hooks for clients whose 'run' differs from docker's e.g. podman
+	mountOpts func(m Mount) string              // options for a mount e.g. "U", none if nil or empty
+	netArgs   func(spec ContainerSpec) []string // args for the spec's network, --net=host for HostNet if nil
+}
+
+// NewCLIRuntime returns a Runtime using the docker command-line client
+func NewCLIRuntime() *CLIRuntime {
+	return &CLIRuntime{Bin: "docker"}
+}
+
+func (c *CLIRuntime) Name() string {
+	return c.bin() + " cli"
+}
+
+func (c *CLIRuntime) bin() string {
+	if c.Bin == "" {
+		return "docker"
+	}
+	return c.Bin
+}
+
+// Run the client with the provided args returning stdout and stderr
+func (c *CLIRuntime) exec(args ...string) ([]byte, []byte, error) {
+	return c.execContext(context.Background(), args...)
+}
+
+// Run the client with the provided args, killing the client if ctx is done
+func (c *CLIRuntime) execContext(ctx context.Context, args ...string) ([]byte, []byte, error) {
+	return c.execEnv(ctx, nil, args...)
+}
+
+// Run the client with extra NAME=value environment, which -e NAME passes on
+// to a container without the value showing up in the client's arguments
+func (c *CLIRuntime) execEnv(ctx context.Context, env []string, args ...string) ([]byte, []byte, error) {
+	cmd := exec.CommandContext(ctx, c.bin(), args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var sOut, sErr bytes.Buffer
+	cmd.Stdout = &sOut
+	cmd.Stderr = &sErr
+	err := cmd.Run()
+	return sOut.Bytes(), sErr.Bytes(), err
+}
+
+func (c *CLIRuntime) ListImages() ([]Image, error) {
+	sOut, sErr, err := c.exec("images")
+	if err != nil {
+		return nil, fmt.Errorf("%s images failed: %v\n%s", c.bin(), err, sErr)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(sOut))
+	images := make([]Image, 0)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Strip off header line, process the rest
+		if !strings.Contains(line, "REPOSITORY") {
+			bits := strings.Fields(line)
+			if len(bits) < 5 {
+				continue
+			}
+			im := Image{
+				FullName: bits[0] + ":" + bits[1],
+				Name:     bits[0],
+				Tag:      bits[1],
+				ID:       bits[2],
+				Created:  bits[3],
+				Size:     bits[len(bits)-1],
+			}
+			images = append(images, im)
+		}
+	}
+	return images, nil
+}
+
+func (c *CLIRuntime) PullImage(name string) error {
+	_, sErr, err := c.exec("pull", name)
+	if err != nil {
+		return fmt.Errorf("%s pull %s failed: %v\n%s", c.bin(), name, err, sErr)
+	}
+	return nil
+}
+
+func (c *CLIRuntime) CreateVolume(name string) error {
+	_, sErr, err := c.exec("volume", "create", name)
+	if err != nil {
+		return fmt.Errorf("%s volume create %s failed: %v\n%s", c.bin(), name, err, sErr)
+	}
+	return nil
+}
+
+func (c *CLIRuntime) RemoveVolume(name string) error {
+	_, sErr, err := c.exec("volume", "rm", name)
+	if err != nil {
+		return fmt.Errorf("%s volume rm %s failed: %v\n%s", c.bin(), name, err, sErr)
+	}
+	return nil
+}
+
+// Build the 'run' args for a container spec
+func (c *CLIRuntime) runArgs(spec ContainerSpec) []string {
+	args := []string{"run"}
+	for _, m := range spec.Mounts {
+		mnt := m.Source + ":" + m.Target
+		if c.mountOpts != nil {
+			if o := c.mountOpts(m); o != "" {
+				mnt += ":" + o
+			}
+		}
+		args = append(args, "-v", mnt)
+	}
+	if spec.Remove {
+		args = append(args, "--rm")
+	}
+	if c.netArgs != nil {
+		args = append(args, c.netArgs(spec)...)
+	} else if spec.HostNet {
+		args = append(args, "--net=host")
+	}
+	if spec.User != "" {
+		args = append(args, "--user="+spec.User)
+	}
+	if spec.WorkDir != "" {
+		args = append(args, "--workdir="+spec.WorkDir)
+	}
+	if spec.Name != "" {
+		args = append(args, "--name", spec.Name)
+	}
+	if spec.Entrypoint != "" {
+		args = append(args, "--entrypoint", spec.Entrypoint)
+	}
+	for _, e := range spec.Env {
+		args = append(args, "-e", e)
+	}
+	for _, e := range spec.Secrets {
+		args = append(args, "-e", envName(e))
+	}
+	args = append(args, spec.Image)
+
+	return append(args, spec.Cmd...)
+}
+
+func (c *CLIRuntime) RunContainer(ctx context.Context, spec ContainerSpec) (ContainerResult, error) {
+	return c.run(ctx, spec, c.runArgs(spec))
+}
+
+// Run a container with the provided 'run' args and collect its result
+func (c *CLIRuntime) run(ctx context.Context, spec ContainerSpec, args []string) (ContainerResult, error) {
+	res := ContainerResult{ID: spec.Name}
+	sOut, sErr, err := c.execEnv(ctx, spec.Secrets, args...)
+	res.Stdout = sOut
+	res.Stderr = sErr
+	// Killing the client doesn't stop the container, that's left to the caller
+	if ctx.Err() != nil {
+		return res, ctx.Err()
+	}
+	if err != nil {
+		ee, ok := err.(*exec.ExitError)
+		// docker and podman run exit 125 when they failed to run the container
+		if !ok || ee.ExitCode() == 125 {
+			return res, fmt.Errorf("%s run %s failed: %v\n%s", c.bin(), spec.Name, err, sErr)
+		}
+		res.ExitCode = ee.ExitCode()
+	}
+	return res, nil
+}
+
+// The NAME of a NAME=value environment variable
+func envName(e string) string {
+	return strings.SplitN(e, "=", 2)[0]
+}
+
+func (c *CLIRuntime) RemoveContainer(name string) error {
+	_, sErr, err := c.exec("rm", "-f", name)
+	if err != nil {
+		return fmt.Errorf("%s rm %s failed: %v\n%s", c.bin(), name, err, sErr)
+	}
+	return nil
+}
+
+func (c *CLIRuntime) CopyFrom(container string, src string, dst string) error {
+	// Make sure dst is a directory so docker cp copies into it
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	_, sErr, err := c.exec("cp", container+":"+src, dst)
+	if err != nil {
+		return fmt.Errorf("%s cp %s:%s failed: %v\n%s", c.bin(), container, src, err, sErr)
+	}
+	return nil
+}
+
+func (c *CLIRuntime) Kill(name string) error {
+	_, sErr, err := c.exec("kill", name)
+	if err != nil {
+		return fmt.Errorf("%s kill %s failed: %v\n%s", c.bin(), name, err, sErr)
+	}
+	return nil
+}
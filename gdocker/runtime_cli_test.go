@@ -0,0 +1,46 @@
+// gdocker
+package gdocker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunArgs(t *testing.T) {
+	spec := ContainerSpec{
+		Name:    "scan_1234",
+		Image:   "gasp-test/scan:1.0",
+		Cmd:     []string{"--out", "scan.json"},
+		User:    "appsecpipeline",
+		WorkDir: "/opt/appsecpipeline/reports",
+		Env:     []string{"A=1"},
+		Secrets: []string{"API_KEY=s3cret"},
+		Mounts:  []Mount{{Source: "data_1234", Target: "/opt/appsecpipeline/"}, {Source: "/home/me/src", Target: "/src"}},
+		HostNet: true,
+		Remove:  true,
+	}
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"docker", NewCLIRuntime().runArgs(spec),
+			"run -v data_1234:/opt/appsecpipeline/ -v /home/me/src:/src --rm --net=host --user=appsecpipeline --workdir=/opt/appsecpipeline/reports " +
+				"--name scan_1234 -e A=1 -e API_KEY gasp-test/scan:1.0 --out scan.json"},
+		{"podman", NewPodmanRuntime().runArgs(spec),
+			"run -v data_1234:/opt/appsecpipeline/:U -v /home/me/src:/src --rm --network=host --user=appsecpipeline --workdir=/opt/appsecpipeline/reports " +
+				"--name scan_1234 -e A=1 -e API_KEY gasp-test/scan:1.0 --out scan.json"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(tt.args, " "); got != tt.want {
+			t.Errorf("%s run args =\n  %s\nwant\n  %s", tt.name, got, tt.want)
+		}
+	}
+
+	// No network args for podman without a network mode
+	p := NewPodmanRuntime()
+	p.Network = ""
+	if got := strings.Join(p.runArgs(spec), " "); strings.Contains(got, "--net") {
+		t.Errorf("podman with no Network set got %s", got)
+	}
+}
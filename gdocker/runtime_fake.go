@@ -0,0 +1,209 @@
+// gdocker
+package gdocker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeRuntime is an in-memory Runtime which records what a run asked for
+// without launching anything.  Useful for testing full pipeline runs.
+type FakeRuntime struct {
+	Images     map[string]bool          // images "available" keyed by name:tag
+	Volumes    map[string]bool          // volumes that currently exist
+	Containers map[string]ContainerSpec // containers kept after running aka no --rm
+	Runs       []ContainerSpec          // every container run, in order
+	Pulled     []string                 // every image pulled, in order
+	Killed     []string                 // every container killed, in order
+	Copied     []string                 // every container:path copied out, in order
+	Files      map[string]string        // contents of files CopyFrom can copy out keyed by path in the container
+	ExitCodes  map[string]int           // exit code to return keyed by container name
+	Output     map[string]string        // stdout to return keyed by container name
+	Errors     map[string]error         // error to return from RunContainer keyed by container name
+	Hang       map[string]bool          // containers which never exit on their own keyed by container name
+	Delay      map[string]time.Duration // how long a container runs for keyed by container name
+	Concurrent [][]string               // the containers running as each container in Runs started, including it
+	running    map[string]bool
+	mu         sync.Mutex
+}
+
+// NewFakeRuntime returns an empty FakeRuntime with the provided images
+// available, along with the helper image every run with a data volume uses
+func NewFakeRuntime(images ...string) *FakeRuntime {
+	f := &FakeRuntime{
+		Images:     make(map[string]bool),
+		Volumes:    make(map[string]bool),
+		Containers: make(map[string]ContainerSpec),
+		ExitCodes:  make(map[string]int),
+		Output:     make(map[string]string),
+		Errors:     make(map[string]error),
+		Hang:       make(map[string]bool),
+		Delay:      make(map[string]time.Duration),
+		Files:      make(map[string]string),
+		running:    make(map[string]bool),
+	}
+	f.Images[helperImage] = true
+	for _, i := range images {
+		f.Images[i] = true
+	}
+	return f
+}
+
+func (f *FakeRuntime) Name() string {
+	return "fake"
+}
+
+func (f *FakeRuntime) ListImages() ([]Image, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	images := make([]Image, 0, len(f.Images))
+	for fn := range f.Images {
+		name, tag := splitImage(fn)
+		images = append(images, Image{FullName: fn, Name: name, Tag: tag})
+	}
+	return images, nil
+}
+
+func (f *FakeRuntime) PullImage(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Images[name] = true
+	f.Pulled = append(f.Pulled, name)
+	return nil
+}
+
+func (f *FakeRuntime) CreateVolume(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Volumes[name] {
+		return fmt.Errorf("volume %s already exists", name)
+	}
+	f.Volumes[name] = true
+	return nil
+}
+
+func (f *FakeRuntime) RemoveVolume(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.Volumes[name] {
+		return fmt.Errorf("no such volume: %s", name)
+	}
+	delete(f.Volumes, name)
+	return nil
+}
+
+func (f *FakeRuntime) RunContainer(ctx context.Context, spec ContainerSpec) (ContainerResult, error) {
+	f.mu.Lock()
+	f.Runs = append(f.Runs, spec)
+	f.running[spec.Name] = true
+	names := make([]string, 0, len(f.running))
+	for n := range f.running {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	f.Concurrent = append(f.Concurrent, names)
+	hang := f.Hang[spec.Name]
+	delay := f.Delay[spec.Name]
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.running, spec.Name)
+		f.mu.Unlock()
+	}()
+
+	// Block a hung container until it's given up on, or a slow one until it's done
+	if hang {
+		<-ctx.Done()
+		return ContainerResult{ID: spec.Name}, ctx.Err()
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ContainerResult{ID: spec.Name}, ctx.Err()
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err, ok := f.Errors[spec.Name]; ok {
+		return ContainerResult{ID: spec.Name}, err
+	}
+	if _, ok := f.Containers[spec.Name]; ok {
+		return ContainerResult{}, fmt.Errorf("container name %s is already in use", spec.Name)
+	}
+	if !f.Images[spec.Image] {
+		return ContainerResult{ID: spec.Name}, fmt.Errorf("no such image: %s", spec.Image)
+	}
+	if !spec.Remove {
+		f.Containers[spec.Name] = spec
+	}
+
+	return ContainerResult{
+		ID:       spec.Name,
+		ExitCode: f.ExitCodes[spec.Name],
+		Stdout:   []byte(f.Output[spec.Name]),
+	}, nil
+}
+
+func (f *FakeRuntime) RemoveContainer(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.Containers[name]; !ok {
+		return fmt.Errorf("no such container: %s", name)
+	}
+	delete(f.Containers, name)
+	return nil
+}
+
+func (f *FakeRuntime) CopyFrom(container string, src string, dst string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.Containers[container]; !ok {
+		return fmt.Errorf("no such container: %s", container)
+	}
+	f.Copied = append(f.Copied, container+":"+src)
+
+	data, ok := f.Files[src]
+	if !ok {
+		return fmt.Errorf("no such file: %s:%s", container, src)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dst, path.Base(src)), []byte(data), 0644)
+}
+
+func (f *FakeRuntime) Kill(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Killed = append(f.Killed, name)
+	return nil
+}
+
+// Split name:tag into its parts, tag defaults to latest
+func splitImage(fn string) (string, string) {
+	// Only look after the last / so registry ports aren't mistaken for tags
+	i := strings.LastIndex(fn, ":")
+	if i < 0 || i < strings.LastIndex(fn, "/") {
+		return fn, "latest"
+	}
+	return fn[:i], fn[i+1:]
+}
@@ -0,0 +1,91 @@
+// gdocker
+package gdocker
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// PodmanRuntime runs containers with the podman command-line client which
+// allows rootless runs on hosts without a Docker daemon
+type PodmanRuntime struct {
+	CLIRuntime
+	Network string // network mode used in place of docker's --net=host, defaults to host
+}
+
+// NewPodmanRuntime returns a Runtime using the podman command-line client
+func NewPodmanRuntime() *PodmanRuntime {
+	p := &PodmanRuntime{CLIRuntime: CLIRuntime{Bin: "podman"}, Network: "host"}
+	p.mountOpts = p.volumeOpts
+	p.netArgs = p.network
+	return p
+}
+
+// Named volumes are chown'ed to the container's user, local paths are left alone
+func (p *PodmanRuntime) volumeOpts(m Mount) string {
+	if strings.HasPrefix(m.Source, "/") {
+		return ""
+	}
+	return "U"
+}
+
+// Podman's --network in place of docker's --net=host
+func (p *PodmanRuntime) network(spec ContainerSpec) []string {
+	if !spec.HostNet || p.Network == "" {
+		return nil
+	}
+	return []string{"--network=" + p.Network}
+}
+
+// Podman maps the container's user onto named volumes with the :U mount
+// option so there's no need for a chown container run as root
+func (p *PodmanRuntime) OwnsVolumes() bool {
+	return true
+}
+
+func (p *PodmanRuntime) ListImages() ([]Image, error) {
+	sOut, sErr, err := p.exec("images", "--format",
+		"{{.Repository}}\t{{.Tag}}\t{{.ID}}\t{{.Created}}\t{{.Size}}")
+	if err != nil {
+		return nil, fmt.Errorf("podman images failed: %v\n%s", err, sErr)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(sOut))
+	images := make([]Image, 0)
+	for scanner.Scan() {
+		bits := strings.Split(scanner.Text(), "\t")
+		if len(bits) < 5 {
+			continue
+		}
+		// Podman lists images with their registry so drop docker hub's
+		// to match the image names used in secpipeline-config.yaml
+		name := strings.TrimPrefix(bits[0], "docker.io/")
+		name = strings.TrimPrefix(name, "library/")
+		im := Image{
+			FullName: name + ":" + bits[1],
+			Name:     name,
+			Tag:      bits[1],
+			ID:       bits[2],
+			Created:  bits[3],
+			Size:     bits[4],
+		}
+		images = append(images, im)
+	}
+	return images, nil
+}
+
+func (p *PodmanRuntime) PullImage(name string) error {
+	// Fully qualify short names so podman doesn't need to prompt for a registry
+	full := name
+	first := strings.Split(name, "/")[0]
+	if !strings.ContainsAny(first, ".:") && first != "localhost" {
+		full = "docker.io/" + name
+	}
+	_, sErr, err := p.exec("pull", full)
+	if err != nil {
+		return fmt.Errorf("podman pull %s failed: %v\n%s", full, err, sErr)
+	}
+	return nil
+}
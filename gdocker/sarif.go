@@ -0,0 +1,239 @@
+// gdocker
+package gdocker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/appsecpipeline/gasp-docker/findings"
+)
+
+// SARIF version and schema written by gasp-docker
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// Base ID for locations relative to where the source is mounted in the
+// containers aka --location
+const sarifSrcRoot = "SRCROOT"
+
+// Just enough of SARIF 2.1.0 for a run's findings
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool                   `json:"tool"`
+	BaseIDs    map[string]sarifArtifactLoc `json:"originalUriBaseIds,omitempty"`
+	Results    []sarifResult               `json:"results"`
+	Properties map[string]string           `json:"properties,omitempty"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string            `json:"id"`
+	ShortDescription sarifMessage      `json:"shortDescription"`
+	Properties       map[string]string `json:"properties,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId,omitempty"`
+	RuleIndex  *int              `json:"ruleIndex,omitempty"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	Physical *sarifPhysical `json:"physicalLocation,omitempty"`
+	Logical  []sarifLogical `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysical struct {
+	Artifact sarifArtifactLoc `json:"artifactLocation"`
+	Region   *sarifRegion     `json:"region,omitempty"`
+}
+
+type sarifArtifactLoc struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+type sarifLogical struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+}
+
+// SARIF levels for gasp-docker's severities
+func sarifLevel(sev string) string {
+	switch findings.Severity(sev) {
+	case findings.SevCritical, findings.SevHigh:
+		return "error"
+	case findings.SevMedium:
+		return "warning"
+	}
+	return "note"
+}
+
+// Where a finding is - a static tool's files are made relative to
+// --location, URLs are kept as is and anything else e.g. host:port is a
+// logical location
+func (run *runInfo) sarifLocation(f findings.Finding, static bool) *sarifLocation {
+	if f.Location == "" {
+		return nil
+	}
+
+	var region *sarifRegion
+	if f.Line > 0 {
+		region = &sarifRegion{StartLine: f.Line}
+	}
+	phys := func(a sarifArtifactLoc) *sarifLocation {
+		return &sarifLocation{Physical: &sarifPhysical{Artifact: a, Region: region}}
+	}
+	if strings.Contains(f.Location, "://") {
+		return phys(sarifArtifactLoc{URI: f.Location})
+	}
+	if static {
+		p := path.Clean(f.Location)
+		switch {
+		case strings.HasPrefix(p, run.loc+"/"):
+			return phys(sarifArtifactLoc{URI: strings.TrimPrefix(p, run.loc+"/"), URIBaseID: sarifSrcRoot})
+		case path.IsAbs(p):
+			return phys(sarifArtifactLoc{URI: "file://" + p})
+		}
+		return phys(sarifArtifactLoc{URI: p, URIBaseID: sarifSrcRoot})
+	}
+	return &sarifLocation{Logical: []sarifLogical{{FullyQualifiedName: f.Location}}}
+}
+
+// Build a SARIF log of the run's findings with a run per tool, in the
+// order the tools were first run
+func (run *runInfo) sarif() sarifLog {
+	sl := sarifLog{Schema: sarifSchema, Version: sarifVersion, Runs: make([]sarifRun, 0)}
+
+	byTool := make(map[string]int)
+	for _, r := range run.results {
+		if r.found == nil {
+			continue
+		}
+		tool := r.tool.Tool
+		i, ok := byTool[tool]
+		if !ok {
+			st := run.toolProfiles[tool]
+			sl.Runs = append(sl.Runs, sarifRun{
+				Tool: sarifTool{Driver: sarifDriver{
+					Name:           tool,
+					Version:        st.ToolVer,
+					InformationURI: st.Url,
+					Rules:          make([]sarifRule, 0),
+				}},
+				BaseIDs: map[string]sarifArtifactLoc{sarifSrcRoot: {URI: "file://" + run.loc + "/"}},
+				Results: make([]sarifResult, 0),
+				Properties: map[string]string{
+					"gasp-run-id": run.runId,
+					"app-name":    run.appName,
+					"profile":     run.name,
+				},
+			})
+			i = len(sl.Runs) - 1
+			byTool[tool] = i
+		}
+		sr := &sl.Runs[i]
+		static := run.toolProfiles[tool].ToolType == "static"
+
+		for _, f := range r.found {
+			res := sarifResult{
+				RuleID:     f.RuleID,
+				Level:      sarifLevel(f.Severity),
+				Message:    sarifMessage{Text: f.Title},
+				Properties: map[string]string{"severity": findings.Severity(f.Severity), "tool-profile": r.tool.ToolProfile},
+			}
+			if f.Evidence != "" {
+				res.Properties["evidence"] = run.mask(f.Evidence)
+			}
+			if l := run.sarifLocation(f, static); l != nil {
+				res.Locations = []sarifLocation{*l}
+			}
+			if f.RuleID != "" {
+				idx := sarifRuleIndex(sr, f)
+				res.RuleIndex = &idx
+			}
+			sr.Results = append(sr.Results, res)
+		}
+	}
+
+	return sl
+}
+
+// Index of a finding's rule in a SARIF run, adding the rule the first time
+// it's seen
+func sarifRuleIndex(sr *sarifRun, f findings.Finding) int {
+	rules := sr.Tool.Driver.Rules
+	for i := range rules {
+		if rules[i].ID == f.RuleID {
+			return i
+		}
+	}
+
+	rule := sarifRule{ID: f.RuleID, ShortDescription: sarifMessage{Text: f.Title}}
+	if f.CWE > 0 {
+		rule.Properties = map[string]string{"cwe": fmt.Sprintf("CWE-%d", f.CWE)}
+	}
+	sr.Tool.Driver.Rules = append(rules, rule)
+	return len(sr.Tool.Driver.Rules) - 1
+}
+
+// Write the run's findings as a SARIF 2.1.0 log
+func (run *runInfo) writeSARIF(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(run.sarif())
+}
+
+// Write the SARIF log to the file from --sarif
+func (run *runInfo) writeSARIFFile() error {
+	if run.sarifFile == "" || run.dryRun {
+		return nil
+	}
+
+	f, err := os.Create(run.sarifFile)
+	if err != nil {
+		return err
+	}
+	if err := run.writeSARIF(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	infoLog.Printf("Wrote the run's findings as SARIF to %s", run.sarifFile)
+	fmt.Printf("SARIF log written to %s\n", run.sarifFile)
+
+	return nil
+}
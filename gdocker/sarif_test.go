@@ -0,0 +1,113 @@
+// gdocker
+package gdocker
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	g "github.com/appsecpipeline/gasp"
+	"github.com/appsecpipeline/gasp-docker/findings"
+)
+
+func TestSARIF(t *testing.T) {
+	run := &runInfo{
+		runId:   "1234",
+		appName: "myapp",
+		name:    "sourcecode",
+		loc:     "/opt/appsecpipeline/source",
+		toolProfiles: map[string]g.SecTool{
+			"bandit": {ToolType: "static", ToolVer: "1.6.2", Url: "https://github.com/PyCQA/bandit"},
+			"zap":    {ToolType: "dynamic"},
+		},
+		results: []*stepResult{
+			{stage: "pipeline", tool: step{Tools: g.Tools{Tool: "bandit", ToolProfile: "all"}}, status: statusPassed, found: []findings.Finding{
+				{Tool: "bandit", RuleID: "B403", Title: "pickle", Severity: findings.SevCritical, Location: "/opt/appsecpipeline/source/app/views.py", Line: 3, CWE: 502},
+				{Tool: "bandit", RuleID: "B101", Title: "assert", Severity: findings.SevLow, Location: "app/tests.py"},
+			}},
+			{stage: "pipeline", tool: step{Tools: g.Tools{Tool: "zap", ToolProfile: "quick"}}, status: statusPassed, found: []findings.Finding{
+				{Tool: "zap", RuleID: "10016", Title: "headers", Severity: findings.SevMedium, Location: "http://example.com/"},
+				{Tool: "zap", Title: "open port", Severity: findings.SevInfo, Location: "example.com:443"},
+			}},
+			// A second bandit step goes in the same run, one with no report adds nothing
+			{stage: "final", tool: step{Tools: g.Tools{Tool: "bandit", ToolProfile: "tuned"}}, status: statusPassed, found: []findings.Finding{
+				{Tool: "bandit", RuleID: "B403", Title: "pickle", Severity: findings.SevHigh, Location: "/etc/passwd"},
+			}},
+			{stage: "final", tool: step{Tools: g.Tools{Tool: "notify"}}, status: statusPassed},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := run.writeSARIF(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &raw); err != nil {
+		t.Fatalf("unable to parse the SARIF log: %v", err)
+	}
+	if raw["version"] != "2.1.0" || raw["$schema"] != "https://json.schemastore.org/sarif-2.1.0.json" {
+		t.Errorf("version %v, $schema %v", raw["version"], raw["$schema"])
+	}
+
+	sl := run.sarif()
+	if len(sl.Runs) != 2 || sl.Runs[0].Tool.Driver.Name != "bandit" || sl.Runs[1].Tool.Driver.Name != "zap" {
+		t.Fatalf("runs = %+v, want bandit then zap", sl.Runs)
+	}
+	bandit := sl.Runs[0]
+	if d := bandit.Tool.Driver; d.Version != "1.6.2" || d.InformationURI != "https://github.com/PyCQA/bandit" || len(d.Rules) != 2 {
+		t.Errorf("bandit driver = %+v", d)
+	}
+	if p := bandit.Properties; p["gasp-run-id"] != "1234" || p["app-name"] != "myapp" || p["profile"] != "sourcecode" {
+		t.Errorf("bandit properties = %v", p)
+	}
+	if bandit.BaseIDs[sarifSrcRoot].URI != "file:///opt/appsecpipeline/source/" {
+		t.Errorf("bandit %s = %+v", sarifSrcRoot, bandit.BaseIDs)
+	}
+
+	// Severities map to levels
+	levels := []string{}
+	for _, sr := range sl.Runs {
+		for _, res := range sr.Results {
+			levels = append(levels, res.Level)
+		}
+	}
+	want := []string{"error", "note", "error", "warning", "note"}
+	if len(levels) != len(want) {
+		t.Fatalf("levels = %v, want %v", levels, want)
+	}
+	for i := range want {
+		if levels[i] != want[i] {
+			t.Errorf("levels = %v, want %v", levels, want)
+			break
+		}
+	}
+
+	// Files under the source mount are relative to it, others are kept whole
+	locs := []struct {
+		res  sarifResult
+		uri  string
+		base string
+	}{
+		{bandit.Results[0], "app/views.py", sarifSrcRoot},
+		{bandit.Results[1], "app/tests.py", sarifSrcRoot},
+		{bandit.Results[2], "file:///etc/passwd", ""},
+		{sl.Runs[1].Results[0], "http://example.com/", ""},
+	}
+	for _, l := range locs {
+		p := l.res.Locations[0].Physical
+		if p == nil || p.Artifact.URI != l.uri || p.Artifact.URIBaseID != l.base {
+			t.Errorf("%s location = %+v, want %s based on %q", l.res.RuleID, p, l.uri, l.base)
+		}
+	}
+	if p := bandit.Results[0].Locations[0].Physical; p.Region == nil || p.Region.StartLine != 3 {
+		t.Errorf("B403 region = %+v, want line 3", p.Region)
+	}
+	if l := sl.Runs[1].Results[1].Locations[0]; l.Physical != nil || l.Logical[0].FullyQualifiedName != "example.com:443" {
+		t.Errorf("open port location = %+v, want logical", l)
+	}
+
+	// Rules are shared between results
+	if *bandit.Results[2].RuleIndex != 0 || *bandit.Results[1].RuleIndex != 1 || bandit.Tool.Driver.Rules[0].Properties["cwe"] != "CWE-502" {
+		t.Errorf("bandit rules = %+v", bandit.Tool.Driver.Rules)
+	}
+}
@@ -0,0 +1,116 @@
+// gdocker
+package gdocker
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	g "github.com/appsecpipeline/gasp"
+)
+
+// A parameter's value is a secret if its data_type is one of these
+var secretTypes = map[string]bool{
+	"key":      true,
+	"password": true,
+	"api":      true,
+}
+
+// Check if a tool parameter holds a secret e.g. DOJO_API_KEY
+func isSecret(pm g.PMeta) bool {
+	return secretTypes[strings.ToLower(pm.DataType)]
+}
+
+// Config parameters e.g. DOJO_API_KEY can be set in an environment variable
+// of the same name so they don't need to be on the command-line
+func configFromEnv(tools map[string]g.SecTool) paramSet {
+	ps := newParamSet("env")
+	for _, t := range tools {
+		for name, pm := range t.Parameters {
+			if pm.PType != "config" {
+				continue
+			}
+			if v, ok := os.LookupEnv(name); ok {
+				ps.set(name, v)
+			}
+		}
+	}
+	return ps
+}
+
+// Warn about any secrets sent with --params
+func warnSecretParams(run *runInfo, sent paramSet) {
+	// Secrets on the command-line end up in shell history and ps output
+	for name := range sent.global {
+		for tool := range run.toolProfiles {
+			if run.isSecretParam(tool, name) {
+				warnSecretParam(name)
+				break
+			}
+		}
+	}
+	for tool, params := range sent.scoped {
+		for name := range params {
+			if run.isSecretParam(tool, name) {
+				warnSecretParam(tool + "." + name)
+			}
+		}
+	}
+}
+
+func warnSecretParam(name string) {
+	warnLog.Printf("Secret parameter %s was sent with --params", name)
+	fmt.Printf("WARNING: %s is a secret, set it in the environment or a --secrets-file rather than --params\n", name)
+}
+
+// Check if a parameter sent for a tool is a secret
+func (run *runInfo) isSecretParam(tool string, name string) bool {
+	pm, ok := run.toolProfiles[tool].Parameters[name]
+	return ok && isSecret(pm)
+}
+
+// Secrets sent for a step's tool as NAME=value for the container's
+// environment, in name order
+func (run *runInfo) secretEnv(s step) []string {
+	env := make([]string, 0)
+	for name, v := range run.sentParams[s.Tool] {
+		if run.isSecretParam(s.Tool, name) {
+			env = append(env, name+"="+v)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// Collect the secrets sent for this run so they can be masked in anything
+// logged or printed
+func (run *runInfo) setSecrets() {
+	pairs := make([]string, 0)
+	seen := make(map[string]bool)
+	for tool, params := range run.sentParams {
+		for name, v := range params {
+			if v == "" || !run.isSecretParam(tool, name) {
+				continue
+			}
+			// Mask the value however it was quoted in a command too
+			for _, f := range []string{quoteValue(v, '"'), quoteValue(v, 0), v} {
+				if !seen[f] {
+					seen[f] = true
+					pairs = append(pairs, f, "********")
+				}
+			}
+		}
+	}
+	if len(pairs) > 0 {
+		run.masker = strings.NewReplacer(pairs...)
+	}
+}
+
+// Mask any secrets in s
+func (run *runInfo) mask(s string) string {
+	if run.masker == nil {
+		return s
+	}
+	return run.masker.Replace(s)
+}
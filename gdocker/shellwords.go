@@ -0,0 +1,99 @@
+// gdocker
+package gdocker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Split a tool command into the arguments sent to its container the way a
+// POSIX shell would, so quoted arguments in secpipeline-config.yaml reach the
+// tool as written e.g. -Plugins "headers;report_xml" is 2 arguments:
+//
+//	'...'  everything inside single quotes is literal
+//	"..."  backslash escapes only \ " $ and ` inside double quotes
+//	\x     outside quotes a backslash makes the next character literal
+//	'' ""  quoted empty strings are kept as empty arguments
+//
+// Unlike a shell, no variable or glob expansion is done.
+func splitCommand(cmd string) ([]string, error) {
+	args := make([]string, 0)
+	var word strings.Builder
+	inWord := false // set once a word has started, even an empty quoted one
+
+	r := []rune(cmd)
+	for i := 0; i < len(r); i++ {
+		c := r[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			// Unquoted whitespace ends the current word
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case c == '\\':
+			inWord = true
+			if i+1 >= len(r) {
+				// A trailing backslash is kept as is
+				word.WriteRune(c)
+				continue
+			}
+			i++
+			// Backslash-newline is a line continuation
+			if r[i] != '\n' {
+				word.WriteRune(r[i])
+			}
+
+		case c == '\'':
+			inWord = true
+			end := indexRune(r, i+1, '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote in command: %s", cmd)
+			}
+			word.WriteString(string(r[i+1 : end]))
+			i = end
+
+		case c == '"':
+			inWord = true
+			closed := false
+			for i++; i < len(r); i++ {
+				if r[i] == '"' {
+					closed = true
+					break
+				}
+				if r[i] == '\\' && i+1 < len(r) && strings.ContainsRune("\\\"$`\n", r[i+1]) {
+					i++
+					if r[i] != '\n' {
+						word.WriteRune(r[i])
+					}
+					continue
+				}
+				word.WriteRune(r[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated double quote in command: %s", cmd)
+			}
+
+		default:
+			inWord = true
+			word.WriteRune(c)
+		}
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+
+	return args, nil
+}
+
+// Index of the first c in r at or after start, -1 if there isn't one
+func indexRune(r []rune, start int, c rune) int {
+	for i := start; i < len(r); i++ {
+		if r[i] == c {
+			return i
+		}
+	}
+	return -1
+}
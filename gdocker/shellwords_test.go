@@ -0,0 +1,39 @@
+// gdocker
+package gdocker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{`nikto -h $URL -Plugins "headers;report_xml"`, []string{"nikto", "-h", "$URL", "-Plugins", "headers;report_xml"}},
+		{`nmap -script "default or (discovery and safe)" $TARGET`, []string{"nmap", "-script", "default or (discovery and safe)", "$TARGET"}},
+		// arachni's autologin keeps the quotes' contents inside the one argument
+		{`--plugin=autologin:url=http://x/login,parameters="user=me&pass=a b",check="Log out"`,
+			[]string{"--plugin=autologin:url=http://x/login,parameters=user=me&pass=a b,check=Log out"}},
+		{`tool '' "" end`, []string{"tool", "", "", "end"}},
+		{`a\ b c\"d "e\"f\$g\x" 'h\i'`, []string{"a b", `c"d`, `e"f$g\x`, `h\i`}},
+		{"one \\\ntwo\t three", []string{"one", "two", "three"}},
+		{`trailing\`, []string{`trailing\`}},
+		{"  ", []string{}},
+	}
+	for _, tt := range tests {
+		got, err := splitCommand(tt.in)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestSplitCommandUnterminated(t *testing.T) {
+	for _, in := range []string{`nikto -Plugins "headers`, `nmap -script 'default`} {
+		if got, err := splitCommand(in); err == nil {
+			t.Errorf("splitCommand(%q) = %q, want an error", in, got)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// gdocker
+package gdocker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"time"
+
+	g "github.com/appsecpipeline/gasp"
+	"gopkg.in/yaml.v2"
+)
+
+// Step settings from master.yaml that gasp's Tools struct doesn't parse
+type stepConf struct {
+	MaxToolRun int `yaml:"max-tool-run"` // minutes before the tool's container is stopped, overrides the global max-tool-run
+}
+
+type profileConf struct {
+	Pipeline []stepConf
+	Startup  []stepConf
+	RunEvery []stepConf
+	Final    []stepConf
+	Params   map[string]interface{} `yaml:"params"` // default tool parameters for this named pipeline
+}
+
+type masterConf struct {
+	Prof   map[string]profileConf `yaml:"profiles"`
+	Params map[string]interface{} `yaml:"params"` // default tool parameters for every named pipeline
+	Global gateConf               `yaml:"global"`
+
+	profFrom  map[string]string      // file a named pipeline came from if not master.yaml
+	appParams map[string]interface{} // default tool parameters from [app-name]-pipeline.yaml
+	appFile   string                 // the [app-name]-pipeline.yaml appParams came from
+}
+
+// A single tool run in a stage of a named pipeline
+type step struct {
+	g.Tools
+	stepConf
+	name  string   // container name if not the default of tool_runId
+	env   []string // extra NAME=value environment variables for the container
+	index int      // position of the step in its stage, from 0
+}
+
+// Name of the container for a step
+func (s step) containerName(run *runInfo) string {
+	if s.name != "" {
+		return s.name
+	}
+	return s.Tool + "_" + run.runId
+}
+
+// Resolve a tool's reportname once per run so every command which refers
+// to {reportname} gets the same value (e.g. the same {timestamp})
+func (run *runInfo) reportName(tool string) string {
+	run.mu.Lock()
+	rn, ok := run.reports[tool]
+	run.mu.Unlock()
+	if ok {
+		return rn
+	}
+
+	rn, un := run.expandReportName(tool)
+	if len(un) > 0 {
+		warnLog.Printf("Unresolved %v in the reportname for %s", un, tool)
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.reports == nil {
+		run.reports = make(map[string]string)
+	}
+	// Another step may have resolved it first, stick with that one
+	if prev, ok := run.reports[tool]; ok {
+		return prev
+	}
+	run.reports[tool] = rn
+	return rn
+}
+
+// Read master.yaml into mstr along with the step settings gasp doesn't know
+// about.  gasp's own reader only prints any errors, so it's read here.
+func readMaster(dir string, file string, mstr *g.M) (masterConf, error) {
+	mc := masterConf{}
+
+	f, err := ioutil.ReadFile(path.Join(dir, file))
+	if err != nil {
+		return mc, err
+	}
+	if err := yaml.Unmarshal(f, mstr); err != nil {
+		return mc, err
+	}
+	err = yaml.Unmarshal(f, &mc)
+
+	return mc, err
+}
+
+// Read secpipeline-config.yaml into sec, its tools are at the top level
+func readSecPipe(dir string, file string, sec *g.S) error {
+	f, err := ioutil.ReadFile(path.Join(dir, file))
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(f, &sec.T)
+}
+
+// Pair up a stage's tools with their settings from master.yaml
+func newStep(t g.Tools, i int, conf []stepConf) step {
+	s := step{Tools: t, index: i}
+	if i < len(conf) {
+		s.stepConf = conf[i]
+	}
+	return s
+}
+
+// Timeout for a step - its own max-tool-run if set, else the global one.
+// Zero means the tool can run forever.
+func (s step) timeout(global g.Gconf) time.Duration {
+	m := global.MaxToolRun
+	if s.MaxToolRun > 0 {
+		m = s.MaxToolRun
+	}
+	if m < 1 {
+		return 0
+	}
+	return time.Duration(m) * maxToolRunUnit
+}
+
+// max-tool-run is in minutes, a var so tests don't have to wait that long
+var maxToolRunUnit = time.Minute
+
+// timeoutError is returned when a tool ran longer than its max-tool-run
+type timeoutError struct {
+	tool  string
+	limit time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("%s exceeded its max-tool-run of %v and was stopped", e.tool, e.limit)
+}
+
+// A stage of a named pipeline and its steps
+type stage struct {
+	name  string
+	steps map[int]step
+}
+
+// The stages of a run in the order they're run - runevery steps run
+// during the pipeline stage so they're listed straight after it
+func (run *runInfo) stages() []stage {
+	return []stage{
+		{"startup", run.startup},
+		{"pipeline", run.pipeline},
+		{"runevery", run.runevery},
+		{"final", run.final},
+	}
+}
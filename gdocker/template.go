@@ -0,0 +1,271 @@
+// gdocker
+package gdocker
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expand a command template from secpipeline-config.yaml:
+//
+//	{name}           a built-in, see builtins() e.g. {reportname} or {runid}
+//	$VAR or ${VAR}   a parameter sent for the tool, matched on its exact name
+//	${VAR:-default}  the parameter, or default if it wasn't sent or is empty
+//	$$               a literal $
+//
+// Braces which don't hold a name e.g. JSON and a $ not followed by a name are
+// left as is.  Anything which couldn't be resolved is returned as written
+// e.g. $LOC or {nosuch}, as is a ${ with no closing brace which is also left
+// in the command.  Variables which aren't in vars and keep returns true for
+// are left for a shell to expand e.g. $HOME, keep can be nil.
+//
+// Values are quoted to suit where they land in the command so a value with
+// spaces or quotes reaches the tool as a single argument.  Variables in refs
+// are in the container's environment so they're left as a shell reference
+// e.g. "${DOJO_API_KEY}" rather than their value, unless they're inside
+// single quotes where a shell can't expand them.
+func expand(tmpl string, builtin func(string) (string, bool), vars map[string]string, refs map[string]bool, keep func(string) bool) (string, []string) {
+	var out strings.Builder
+	unresolved := make([]string, 0)
+	var q byte // the quote the template is inside of, if any
+
+	value := func(name string, v string) string {
+		if !refs[name] || q == '\'' {
+			return quoteValue(v, q)
+		}
+		if q == '"' {
+			return "${" + name + "}"
+		}
+		return `"${` + name + `}"`
+	}
+
+	for i := 0; i < len(tmpl); i++ {
+		c := tmpl[i]
+		switch {
+		case c == '{':
+			end := strings.IndexByte(tmpl[i+1:], '}')
+			if end < 0 || !isPlaceholder(tmpl[i+1:i+1+end]) {
+				out.WriteByte(c)
+				continue
+			}
+			name := tmpl[i+1 : i+1+end]
+			if v, ok := builtin(name); ok {
+				out.WriteString(quoteValue(v, q))
+			} else {
+				unresolved = append(unresolved, "{"+name+"}")
+			}
+			i += end + 1
+
+		case c == '$' && i+1 < len(tmpl) && tmpl[i+1] == '$':
+			out.WriteByte('$')
+			i++
+
+		case c == '$' && i+1 < len(tmpl) && tmpl[i+1] == '{':
+			end := strings.IndexByte(tmpl[i+2:], '}')
+			if end < 0 {
+				out.WriteString(tmpl[i:])
+				unresolved = append(unresolved, tmpl[i:]+" (no closing })")
+				i = len(tmpl)
+				continue
+			}
+			name := tmpl[i+2 : i+2+end]
+			def, hasDef := "", false
+			if d := strings.Index(name, ":-"); d >= 0 {
+				name, def, hasDef = name[:d], name[d+2:], true
+			}
+			v, ok := vars[name]
+			if ok && (v != "" || !hasDef) {
+				out.WriteString(value(name, v))
+			} else if !ok && keep != nil && keep(name) {
+				out.WriteString(tmpl[i : i+3+end])
+			} else if hasDef {
+				out.WriteString(def)
+			} else {
+				unresolved = append(unresolved, "${"+name+"}")
+			}
+			i += end + 2
+
+		case c == '$' && i+1 < len(tmpl) && isNameStart(tmpl[i+1]):
+			j := i + 1
+			for j < len(tmpl) && isNameChar(tmpl[j]) {
+				j++
+			}
+			name := tmpl[i+1 : j]
+			if v, ok := vars[name]; ok {
+				out.WriteString(value(name, v))
+			} else if keep != nil && keep(name) {
+				out.WriteString("$" + name)
+			} else {
+				unresolved = append(unresolved, "$"+name)
+			}
+			i = j - 1
+
+		case c == '\\' && q != '\'' && i+1 < len(tmpl):
+			// An escaped character doesn't change the quoting
+			out.WriteByte(c)
+			out.WriteByte(tmpl[i+1])
+			i++
+
+		default:
+			if (c == '\'' || c == '"') && (q == 0 || q == c) {
+				if q == 0 {
+					q = c
+				} else {
+					q = 0
+				}
+			}
+			out.WriteByte(c)
+		}
+	}
+
+	return out.String(), unresolved
+}
+
+// Quote a value for where it's going in a command - q is the quote it's
+// inside of, if any.  Values inside single quotes can't be escaped so
+// they're used as is.
+func quoteValue(v string, q byte) string {
+	switch q {
+	case '"':
+		r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+		return r.Replace(v)
+	case '\'':
+		return v
+	}
+	if v == "" || strings.Trim(v, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-") == "" {
+		return v
+	}
+	return "'" + strings.Replace(v, "'", `'\''`, -1) + "'"
+}
+
+// Variable names are shell style - letters, digits and _ not starting with a digit
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// A {placeholder} is a name like reportname, anything else in braces is left alone
+func isPlaceholder(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isNameChar(s[i]) && s[i] != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// Built-in {placeholders} for a step's commands:
+//
+//	{reportname}  the tool's report from its reportname command, as a full
+//	              path in the data volume's reports directory
+//	{timestamp}   Unix time in nanoseconds
+//	{date}        today's date as YYYY-MM-DD
+//	{runid}       the ID of this run
+//	{appname}     the app name sent with --app-name
+//	{tool}        the tool's name e.g. bandit
+//	{profile}     the tool-profile the tool is run with
+func (run *runInfo) builtins(s step) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		switch name {
+		case "reportname":
+			if run.toolProfiles[s.Tool].Cmds["reportname"] == "" {
+				return "", false
+			}
+			return reportPath(run.reportName(s.Tool)), true
+		case "timestamp":
+			// TODO: Check if Unix nanoseconds is right timestamp to use
+			return strconv.Itoa(int(time.Now().UnixNano())), true
+		case "date":
+			return time.Now().Format("2006-01-02"), true
+		case "runid":
+			return run.runId, true
+		case "appname":
+			return run.appName, true
+		case "tool":
+			return s.Tool, true
+		case "profile":
+			return s.ToolProfile, s.ToolProfile != ""
+		}
+		return "", false
+	}
+}
+
+// Variables available to a step's commands - the parameters sent for the
+// tool plus any extra environment for the step e.g. runevery's GASP_STEP_*
+func (run *runInfo) toolVars(s step) map[string]string {
+	vars := make(map[string]string)
+	for k, v := range run.sentParams[s.Tool] {
+		vars[k] = v
+	}
+	for _, e := range s.env {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) == 2 {
+			vars[kv[0]] = kv[1]
+		}
+	}
+	return vars
+}
+
+// Expand one of a step's commands, adding anything unresolved to missing.
+// refs are the secrets which can be referenced from the container's
+// environment rather than put in the command.  A shell tool's commands can
+// use the shell's own variables e.g. $HOME, so only the tool's parameters
+// have to resolve for it.
+func cmdSub(c string, s step, run *runInfo, missing map[string]bool, refs map[string]bool) string {
+	var keep func(string) bool
+	if t := run.toolProfiles[s.Tool]; toolShell(t) {
+		keep = func(name string) bool {
+			_, ok := t.Parameters[name]
+			return !ok
+		}
+	}
+	out, un := expand(c, run.builtins(s), run.toolVars(s), refs, keep)
+	for _, u := range un {
+		missing[u] = true
+	}
+	return out
+}
+
+// Expand a tool's reportname command, which can't refer to itself or the
+// tool-profile since it's shared by every step using the tool
+func (run *runInfo) expandReportName(tool string) (string, []string) {
+	s := step{}
+	s.Tool = tool
+	b := run.builtins(s)
+	return expand(run.toolProfiles[tool].Cmds["reportname"], func(name string) (string, bool) {
+		if name == "reportname" {
+			return "", false
+		}
+		return b(name)
+	}, run.toolVars(s), nil, nil)
+}
+
+// unresolvedErr lists what couldn't be resolved in a step's commands
+type unresolvedErr struct {
+	tool    string
+	profile string
+	names   []string // as written e.g. $LOC or {nosuch}
+}
+
+func (e *unresolvedErr) Error() string {
+	return fmt.Sprintf("unresolved in the command for %s (%s): %s - send any parameters with --params",
+		e.tool, e.profile, strings.Join(e.names, ", "))
+}
+
+func unresolvedError(s step, missing map[string]bool) error {
+	names := make([]string, 0, len(missing))
+	for m := range missing {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+	return &unresolvedErr{tool: s.Tool, profile: s.ToolProfile, names: names}
+}
@@ -0,0 +1,71 @@
+// gdocker
+package gdocker
+
+import (
+	"reflect"
+	"testing"
+
+	g "github.com/appsecpipeline/gasp"
+)
+
+func TestExpand(t *testing.T) {
+	builtin := func(name string) (string, bool) {
+		if name == "runid" {
+			return "1234", true
+		}
+		return "", false
+	}
+	vars := map[string]string{"LOC": "/opt/src", "DOJO_LOC": "/opt/dojo", "EMPTY": "", "SPACED": "a b"}
+
+	tests := []struct {
+		in         string
+		want       string
+		unresolved []string
+	}{
+		// Names match exactly, LOC isn't a prefix of LOCATION and DOJO_LOC isn't LOC
+		{"bandit -r $LOC --dojo=$DOJO_LOC", "bandit -r /opt/src --dojo=/opt/dojo", []string{}},
+		{"$LOCATION ${LOC}x", " /opt/srcx", []string{"$LOCATION"}},
+		{"--out {runid}.json {nosuch}", "--out 1234.json ", []string{"{nosuch}"}},
+		{"${MISSING:-def} ${EMPTY:-def} ${EMPTY} ${LOC:-def} ${MISSING:-}", "def def  /opt/src ", []string{}},
+		{"${MISSING}", "", []string{"${MISSING}"}},
+		{"$$LOC costs $$5 $ {\"json\": 1}", "$LOC costs $5 $ {\"json\": 1}", []string{}},
+		{"-x $SPACED \"$SPACED\" '$SPACED'", "-x 'a b' \"a b\" 'a b'", []string{}},
+		// An unterminated ${ is kept rather than dropped
+		{"-d ${LOC -v", "-d ${LOC -v", []string{"${LOC -v (no closing })"}},
+	}
+	for _, tt := range tests {
+		got, un := expand(tt.in, builtin, vars, nil, nil)
+		if got != tt.want || !reflect.DeepEqual(un, tt.unresolved) {
+			t.Errorf("expand(%q) = %q, %q, want %q, %q", tt.in, got, un, tt.want, tt.unresolved)
+		}
+	}
+}
+
+func TestExpandKeep(t *testing.T) {
+	// A shell tool keeps variables which aren't its parameters for the shell
+	keep := func(name string) bool { return name != "LOC" }
+	got, un := expand("cd $HOME && ls ${PWD:-/} $LOC ${TOKEN}", func(string) (string, bool) { return "", false },
+		map[string]string{"TOKEN": "s3cret"}, map[string]bool{"TOKEN": true}, keep)
+	if want := `cd $HOME && ls ${PWD:-/}  "${TOKEN}"`; got != want || len(un) != 1 || un[0] != "$LOC" {
+		t.Errorf("expand = %q, %q, want %q with $LOC unresolved", got, un, want)
+	}
+}
+
+func TestCmdSubShell(t *testing.T) {
+	run := &runInfo{toolProfiles: map[string]g.SecTool{
+		"sh":   {Parameters: map[string]g.PMeta{"LOC": {}}, Cmds: map[string]string{"shell": "True"}},
+		"bare": {Parameters: map[string]g.PMeta{"LOC": {}}},
+	}}
+	for _, tool := range []string{"sh", "bare"} {
+		missing := make(map[string]bool)
+		s := step{Tools: g.Tools{Tool: tool}}
+		out := cmdSub("cd $HOME && scan $LOC", s, run, missing, nil)
+		// A missing parameter always stops the run, $HOME only for a tool with no shell
+		if tool == "sh" && (out != "cd $HOME && scan " || len(missing) != 1 || !missing["$LOC"]) {
+			t.Errorf("%s: cmdSub = %q, missing %v, want $HOME kept and $LOC missing", tool, out, missing)
+		}
+		if tool == "bare" && (len(missing) != 2 || !missing["$HOME"]) {
+			t.Errorf("%s: cmdSub = %q, missing %v, want $HOME and $LOC missing", tool, out, missing)
+		}
+	}
+}
@@ -8,4 +8,5 @@ require (
 	github.com/satori/go.uuid v1.2.0
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/viper v1.4.0
+	gopkg.in/yaml.v2 v2.2.2
 )